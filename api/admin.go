@@ -0,0 +1,261 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/davinche/gpies/config"
+	"github.com/davinche/gpies/ingest"
+	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/store"
+	"github.com/dimfeld/httptreemux"
+)
+
+// piePatch is the body of a request to change the price or labels of a pie
+type piePatch struct {
+	Price  *pie.Cents `json:"price_per_slice"`
+	Labels *[]string  `json:"labels"`
+}
+
+// restockRequest is the body of a request to restock a pie
+type restockRequest struct {
+	Slices int `json:"slices"`
+}
+
+// restockResponse is the number of slices of a pie after restocking it
+type restockResponse struct {
+	ID              uint64 `json:"id"`
+	RemainingSlices int    `json:"remaining_slices"`
+}
+
+// invalidPieError is returned when an edited pie has problems
+type invalidPieError struct {
+	problems []*ingest.Problem
+}
+
+func (e *invalidPieError) Error() string {
+	return fmt.Sprintf("invalid pie: %d problems", len(e.problems))
+}
+
+// authenticated only lets requests with the admin token through
+func authenticated(h httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		if config.Config.AdminToken == "" {
+			encodeJSON(w, errorResponse{"The admin API is disabled."}, http.StatusForbidden)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.Config.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gpies"`)
+			encodeJSON(w, errorResponse{"Missing or wrong admin token."}, http.StatusUnauthorized)
+			return
+		}
+		h(w, r, params)
+	}
+}
+
+// createPie adds a pie to the catalog
+func createPie(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	p := &pie.Pie{}
+	err := json.NewDecoder(r.Body).Decode(p)
+	if err != nil {
+		encodeBadRequest(w, fmt.Sprintf("error: could not decode pie: err=%q", err))
+		return
+	}
+
+	if p.ID == 0 {
+		encodeBadRequest(w, "error: missing information: missing id")
+		return
+	}
+
+	p.Labels = expandLabels(p.Labels)
+	err = pieStore.EditPie(p.ID, func(old *pie.Pie) (*pie.Pie, error) {
+		if old != nil {
+			return nil, store.ErrPieExists
+		}
+		return p, validPie(p)
+	})
+	if err != nil {
+		editError(w, r, err)
+		return
+	}
+
+	p.Permalink = permalink(r, p.ID)
+	w.Header().Set("Location", p.Permalink)
+	encodeJSON(w, p, http.StatusCreated)
+}
+
+// replacePie replaces every field of a pie. The remaining slices are left
+// alone, use the restock endpoint to change them.
+func replacePie(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := strconv.ParseUint(params["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	p := &pie.Pie{}
+	err = json.NewDecoder(r.Body).Decode(p)
+	if err != nil {
+		encodeBadRequest(w, fmt.Sprintf("error: could not decode pie: err=%q", err))
+		return
+	}
+
+	if p.ID != 0 && p.ID != id {
+		encodeBadRequest(w, "error: id does not match the id of the url")
+		return
+	}
+	p.ID = id
+	p.Labels = expandLabels(p.Labels)
+
+	err = pieStore.EditPie(id, func(old *pie.Pie) (*pie.Pie, error) {
+		if old == nil {
+			return nil, store.ErrNotFound
+		}
+		return p, validPie(p)
+	})
+	if err != nil {
+		editError(w, r, err)
+		return
+	}
+
+	p.Permalink = permalink(r, p.ID)
+	encodeJSON(w, p, nil)
+}
+
+// patchPie changes the price and/or the labels of a pie
+func patchPie(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := strconv.ParseUint(params["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	patch := piePatch{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&patch)
+	if err != nil {
+		encodeBadRequest(w, fmt.Sprintf("error: could not decode patch, only price_per_slice and labels can be patched: err=%q", err))
+		return
+	}
+
+	if patch.Price == nil && patch.Labels == nil {
+		encodeBadRequest(w, "error: missing information: missing price_per_slice or labels")
+		return
+	}
+
+	var p *pie.Pie
+	err = pieStore.EditPie(id, func(old *pie.Pie) (*pie.Pie, error) {
+		if old == nil {
+			return nil, store.ErrNotFound
+		}
+
+		p = old
+		if patch.Price != nil {
+			p.Price = *patch.Price
+		}
+		if patch.Labels != nil {
+			p.Labels = expandLabels(*patch.Labels)
+		}
+		return p, validPie(p)
+	})
+	if err != nil {
+		editError(w, r, err)
+		return
+	}
+
+	p.Permalink = permalink(r, p.ID)
+	encodeJSON(w, p, nil)
+}
+
+// deletePie retires a pie. Purchases of the pie are kept.
+func deletePie(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := strconv.ParseUint(params["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = pieStore.EditPie(id, func(old *pie.Pie) (*pie.Pie, error) {
+		if old == nil {
+			return nil, store.ErrNotFound
+		}
+		return nil, nil
+	})
+	if err != nil {
+		editError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restockPie adds slices to a pie and makes it available again
+func restockPie(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := strconv.ParseUint(params["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	req := restockRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		encodeBadRequest(w, fmt.Sprintf("error: could not decode restock: err=%q", err))
+		return
+	}
+
+	if req.Slices < 1 {
+		encodeBadRequest(w, "error: slices is not a positive integer")
+		return
+	}
+
+	remaining, err := pieStore.Restock(params["id"], req.Slices)
+	if err != nil {
+		editError(w, r, err)
+		return
+	}
+	encodeJSON(w, restockResponse{id, remaining}, nil)
+}
+
+// expandLabels applies the label taxonomy to the labels of an edited pie
+func expandLabels(labels []string) []string {
+	if len(labels) == 0 {
+		return labels
+	}
+	return labelTaxonomy.Expand(labels)
+}
+
+// validPie returns an invalidPieError when the pie has problems
+func validPie(p *pie.Pie) error {
+	if problems := ingest.ValidatePie(p); len(problems) > 0 {
+		return &invalidPieError{problems}
+	}
+	return nil
+}
+
+// editError reports the reason a pie could not be edited
+func editError(w http.ResponseWriter, r *http.Request, err error) {
+	if invalidErr, ok := err.(*invalidPieError); ok {
+		errors := []string{}
+		for _, p := range invalidErr.problems {
+			errors = append(errors, fmt.Sprintf("error: %s: %s", p.Field, p.Message))
+		}
+		encodeBadRequest(w, errors...)
+		return
+	}
+
+	switch err {
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+	case store.ErrPieExists:
+		encodeJSON(w, errorResponse{"A pie with that id already exists."}, http.StatusConflict)
+	default:
+		storeError(w, err)
+	}
+}
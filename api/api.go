@@ -1,299 +1,165 @@
 package api
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/davinche/gpies/config"
 	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/recommender"
+	"github.com/davinche/gpies/store"
+	"github.com/davinche/gpies/taxonomy"
 	"github.com/dimfeld/httptreemux"
-	"github.com/garyburd/redigo/redis"
 )
 
-// Redis Connection Pool
-var pool *redis.Pool
+// pieStore is where the API reads and writes pies and purchases
+var pieStore store.PieStore
+
+// strategies are the recommendation strategies by name and experiment assigns
+// users to one of them
+var strategies map[string]recommender.Strategy
+var experiment *recommender.Experiment
+
+// labelTaxonomy defines the implications and aliases of labels
+var labelTaxonomy *taxonomy.Taxonomy
 var hw = []byte("Hello, World!")
 
-// Create a redis pool connection on API init
-func init() {
-	pool = &redis.Pool{
-		MaxIdle:   80,
-		MaxActive: 1000,
-		Dial: func() (redis.Conn, error) {
-			redisOpts := []redis.DialOption{}
-			if config.Config.RedisPassword != "" {
-				redisOpts = append(redisOpts, redis.DialPassword(config.Config.RedisPassword))
-			}
-			c, err := redis.Dial("tcp", config.Config.Redis, redisOpts...)
-			if err != nil {
-				log.Fatalf("error: could not create redis connection pool: err=%q\n", err)
-			}
-			return c, err
-		},
+// Handle takes a prefix (the prefix route for the API) and registers
+// functions that will handle the API requests using the given store.
+// The label taxonomy, which may be nil, is applied to the labels of queries
+// and of the pies edited through the admin API.
+func Handle(prefix string, r *httptreemux.TreeMux, s store.PieStore, t *taxonomy.Taxonomy) {
+	pieStore = s
+	labelTaxonomy = t
+
+	var err error
+	strategies = recommender.Strategies(s)
+	experiment, err = recommender.NewExperiment(strategies, config.Config.RecommendStrategies)
+	if err != nil {
+		log.Fatalf("error: could not set up the recommendation strategies: err=%q", err)
 	}
-}
 
-// Handle takes a prefix (the prefix route for the API) and registers
-// functions that will handle the API requests
-func Handle(prefix string, r *httptreemux.TreeMux) {
 	api := r.NewGroup(prefix)
 	api.GET("/hello_world", helloWorld)
 	api.GET("/pies", getPies)
+	api.GET("/pies.json", getPies)
+	api.GET("/pies.csv", getPies)
 	api.GET("/pie/:id", getPie)
 	api.GET("/pies/recommend", getRecommended)
-	api.POST("/pie/:id/purchases", purchasePie)
+	api.GET("/labels", getLabels)
+	api.GET("/labels.json", getLabels)
+	api.POST("/pie/:id/purchases", idempotent(purchasePie))
+	api.DELETE("/pie/:id/purchases", refundPie)
+	api.POST("/orders", idempotent(createOrder))
+	api.GET("/orders/:id", getOrder)
+	api.GET("/users/:username/purchases", getUserPurchases)
+	api.GET("/users/:username/purchases.json", getUserPurchases)
+	api.POST("/admin/pies", authenticated(createPie))
+	api.PUT("/admin/pies/:id", authenticated(replacePie))
+	api.PATCH("/admin/pies/:id", authenticated(patchPie))
+	api.DELETE("/admin/pies/:id", authenticated(deletePie))
+	api.POST("/admin/pies/:id/restock", authenticated(restockPie))
 }
 
 func helloWorld(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 	w.Write(hw)
 }
 
-// getPies returns the list of all pies
+// getPies returns the list of pies as HTML, JSON or CSV. The pies can be
+// filtered, sorted and paginated, except for the CSV list which contains
+// every pie matching the filters.
 func getPies(w http.ResponseWriter, r *http.Request, _ map[string]string) {
-	conn := pool.Get()
-	defer conn.Close()
+	_, format := negotiateFormat(w, r, r.URL.Path, formatJSON, formatCSV)
 
-	// Get all the pies
-	piesBytes, err := redis.Bytes(conn.Do("GET", PiesJSONKey))
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("error: could not get pies from Redis: err=%q\n", err)
+	params, errors := getPieListParams(r)
+	if errors != nil {
+		encodeBadRequest(w, errors...)
 		return
 	}
 
-	// Unmarshall
-	pies := pie.Pies{}
-	err = json.Unmarshal(piesBytes, &pies)
-
+	pies, err := pieStore.Pies(params.labels)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("error: could not unmarshall pies: err=%q\n", err)
+		log.Printf("error: could not get pies: err=%q\n", err)
 		return
 	}
 
+	pies = filterPies(pies, params)
 	for _, p := range pies {
-		// Grab remainig slices for the pie
-		slicesKey := fmt.Sprintf(PieSlicesKey, strconv.FormatUint(p.ID, 10))
-		slices, err := redis.Int(conn.Do("GET", slicesKey))
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("error: could not get pie slices: err=%q\n", err)
-			return
-		}
-		p.Slices = slices
-		p.Permalink = "http://" + r.Host + "/pie/" + strconv.FormatUint(p.ID, 10)
-	}
-
-	PiesList.Execute(w, pies)
-}
-
-// getPie returns the information for a single pie
-func getPie(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	conn := pool.Get()
-	defer conn.Close()
-	pieID := params["id"]
-	isJSON := false
-	if strings.HasSuffix(pieID, ".json") {
-		isJSON = true
-		pieID = pieID[:strings.LastIndex(pieID, ".")]
+		p.Permalink = permalink(r, p.ID)
 	}
 
-	// Redis Keys that we need
-	key := fmt.Sprintf(PieKey, pieID)
-	slicesKey := fmt.Sprintf(PieSlicesKey, pieID)
-	piePurchasersKey := fmt.Sprintf(PiePurchasersKey, pieID)
-	log.Printf("debug: pieKey=%q, slicesKey=%q, purchasersKey=%q\n", key, slicesKey, piePurchasersKey)
-
-	// Pie to eventually serialize
-	details := pie.Details{
-		Purchases: []*pie.Purchases{},
-	}
-
-	conn.Send("MULTI")
-	conn.Send("GET", key)
-	conn.Send("GET", slicesKey)
-	conn.Send("SMEMBERS", piePurchasersKey)
-	resp, err := redis.Values(conn.Do("EXEC"))
-	if err != nil {
-		redisError(w, err)
+	if format == formatCSV {
+		encodeCSV(w, "pies.csv", pieListCSV(pies))
 		return
 	}
 
-	// Get the pie data from redis
-	pieBytes, err := redis.Bytes(resp[0], nil)
-	if err != nil {
-		redisError(w, err)
+	page := paginatePies(r, pies, params)
+	if format == formatJSON {
+		encodeJSON(w, newPieList(page), nil)
 		return
 	}
+	PiesList.Execute(w, page)
+}
 
-	// Unmarshall into pie object
-	err = json.Unmarshal(pieBytes, &details)
-	if err != nil {
-		redisError(w, err)
-		return
-	}
+// getPie returns the information for a single pie
+func getPie(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	pieID, format := negotiateFormat(w, r, params["id"], formatJSON)
 
-	// Get the number of slices
-	slices, err := redis.Int(resp[1], nil)
-	if err != nil {
-		redisError(w, err)
+	details, err := pieStore.Pie(pieID)
+	if err == store.ErrNotFound {
+		http.NotFound(w, r)
 		return
 	}
-
-	// get purchaser IDs
-	members, err := redis.Values(resp[2], nil)
 	if err != nil {
-		redisError(w, err)
+		storeError(w, err)
 		return
 	}
-
-	// Get number of slices by each purchaser
-	for _, member := range members {
-		memberName, err := redis.String(member, nil)
-		if err != nil {
-			redisError(w, err)
-			return
-		}
-		purchasesKey := fmt.Sprintf(PurchaseKey, pieID, memberName)
-		numSlices, err := redis.Int(conn.Do("GET", purchasesKey))
-		if err != nil {
-			redisError(w, err)
-			return
-		}
-		details.Purchases = append(details.Purchases, &pie.Purchases{
-			Username: memberName,
-			Slices:   numSlices,
-		})
-	}
-
-	// serializes
-	details.Pie.Slices = 0
-	details.RemainingSlices = slices
+	details.Permalink = permalink(r, details.ID)
 
 	// showing json? Or rendering template
-	if isJSON {
+	if format == formatJSON {
 		encodeJSON(w, details, nil)
 		return
 	}
 	PiesSingle.Execute(w, details)
 }
 
-// getRecommended gets a recommended pie for a given user
-func getRecommended(w http.ResponseWriter, r *http.Request, _ map[string]string) {
-	conn := pool.Get()
-	defer conn.Close()
-
-	// Get the from data
-	username := r.FormValue("username")
-	budget := r.FormValue("budget")
-	labelsStr := r.FormValue("labels")
-
-	// Split labels by delimiter ","
-	var labels []string
-	if labelsStr != "" {
-		labels = strings.Split(labelsStr, ",")
-	}
-
-	log.Printf("debug: username=%q, budget=%q, labels=%q\n", username, budget, labelsStr)
-
-	// List of sets we are going to intersect with to narrow down the pies
-	// we can recommend to the user
-	query := []interface{}{
-		PiesAvailableKey,
-	}
-
-	// Check to see if there is a list of pies available to a user
-	userAvailableKey := fmt.Sprintf(UserAvailableKey, username)
-	exists, err := redis.Bool(conn.Do("EXISTS", userAvailableKey))
-	if err != nil {
-		redisError(w, err)
-		return
-	}
-
-	// Filter by pies available to current user if possible
-	log.Printf("debug: userAvailableKey=%v\n", userAvailableKey)
-	if exists {
-		query = append(query, userAvailableKey)
-	}
-
-	// Figure out which labels to filter by
-	if len(labels) > 0 {
-		for _, label := range labels {
-			query = append(query, fmt.Sprintf(LabelKey, label))
-		}
-	}
-
-	log.Printf("debug: query=%v\n", query)
-
-	// Query redis for the intersecting pies
-	recommendedPieIDs, err := redis.Values(conn.Do("SINTER", query...))
-	if err != nil {
-		redisError(w, err)
-		return
-	}
+// permalink returns the URL of the page of a pie
+func permalink(r *http.Request, id uint64) string {
+	return "http://" + r.Host + "/pie/" + strconv.FormatUint(id, 10)
+}
 
-	// Are there any pies to recommend
-	if len(recommendedPieIDs) == 0 {
-		noRecommended(w)
-		return
+// getLabelQuery reads the label filters of a request. Each parameter is a list
+// of labels separated by ",": labels are all required, labels_any requires at
+// least one of them and labels_none excludes the pies that carry any of them.
+func getLabelQuery(r *http.Request) store.LabelQuery {
+	return store.LabelQuery{
+		All:  labelTaxonomy.Normalize(splitLabels(r.FormValue("labels"))),
+		Any:  labelTaxonomy.Normalize(splitLabels(r.FormValue("labels_any"))),
+		None: labelTaxonomy.Normalize(splitLabels(r.FormValue("labels_none"))),
 	}
+}
 
-	// Get all the pies to recommend
-	listOfPies := make(pie.RecommendPies, len(recommendedPieIDs))
-
-	// Get the pie details
-	for index, id := range recommendedPieIDs {
-		pKey := fmt.Sprintf(HPieKey, id)
-		values, err := redis.Values(conn.Do("HMGET", pKey, "id", "price"))
-		if err != nil {
-			redisError(w, err)
-			return
-		}
-
-		id, err := redis.Uint64(values[0], nil)
-		if err != nil {
-			errMsg := fmt.Sprintf("error: could not get pie id: err=%q", err)
-			log.Println(errMsg)
-			encodeError(w, errMsg)
-			return
-		}
-
-		price, err := redis.Float64(values[1], nil)
-		if err != nil {
-			errMsg := fmt.Sprintf("error: could not get pie price: err=%q", err)
-			log.Println(errMsg)
-			encodeError(w, errMsg)
-			return
-		}
-
-		pieObj := &pie.RecommendPie{
-			ID:    id,
-			Price: price,
+// splitLabels splits a list of labels by the delimiter ","
+func splitLabels(labelsStr string) []string {
+	labels := []string{}
+	for _, label := range strings.Split(labelsStr, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
 		}
-
-		listOfPies[index] = pieObj
-	}
-
-	// Sort by budget
-	if budget == "cheap" {
-		sort.Sort(listOfPies)
 	}
-
-	if budget == "premium" {
-		sort.Sort(sort.Reverse(listOfPies))
-	}
-
-	recommend(w, r, listOfPies[0])
+	return labels
 }
 
 // getPurchaseParams validates an incoming request and ensures that all
 // required data is provided
-func getPurchaseParams(r *http.Request) (username string, amount float64, slices int, errors []string) {
+func getPurchaseParams(r *http.Request) (username string, amount pie.Cents, slices int, errors []string) {
 	// Get purchase information
 	username = r.URL.Query().Get("username")
 	amountStr := r.URL.Query().Get("amount")
@@ -317,14 +183,14 @@ func getPurchaseParams(r *http.Request) (username string, amount float64, slices
 		slicesStr = "1"
 	}
 
-	amount, err := strconv.ParseFloat(amountStr, 64)
+	amount, err := pie.ParseCents(amountStr)
 	if err != nil {
 		errors = append(errors, "amount is not a decimal")
 	}
 
 	slices, err = strconv.Atoi(slicesStr)
-	if err != nil {
-		errors = append(errors, "error: slices is not an integer")
+	if err != nil || slices < 1 {
+		errors = append(errors, "error: slices is not a positive integer")
 	}
 
 	if errors != nil {
@@ -335,27 +201,7 @@ func getPurchaseParams(r *http.Request) (username string, amount float64, slices
 
 // purchasePie is the endpoint that allows users to purchase the pie
 func purchasePie(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	conn := pool.Get()
-	defer conn.Close()
-
 	pieID := params["id"]
-	key := fmt.Sprintf(PieKey, pieID)
-	hkey := fmt.Sprintf(HPieKey, pieID)
-	slicesKey := fmt.Sprintf(PieSlicesKey, pieID)
-	piePurchasersKey := fmt.Sprintf(PiePurchasersKey, pieID)
-
-	// Make sure the pie exists
-	exists, err := redis.Bool(conn.Do("EXISTS", key))
-	if err != nil {
-		redisError(w, err)
-		return
-	}
-
-	// Return not found if the requested pie does not exist
-	if !exists {
-		http.NotFound(w, r)
-		return
-	}
 
 	// get the parameters
 	username, amount, wantedSlices, errors := getPurchaseParams(r)
@@ -364,169 +210,74 @@ func purchasePie(w http.ResponseWriter, r *http.Request, params map[string]strin
 		return
 	}
 
-	// Simple check for gluttony if slices > 3
-	if wantedSlices > 3 {
-		log.Printf("debug: gluttony: wanted=%d\n", wantedSlices)
-		gluttony(w)
-		return
-	}
-
-	// Check purchases to see how many slices the user already bought
-	purchasesKey := fmt.Sprintf(PurchaseKey, pieID, username)
-	purchases, err := conn.Do("GET", purchasesKey)
-	if err != nil {
-		redisError(w, err)
+	order, err := pieStore.Purchase(pieID, username, amount, wantedSlices)
+	if limitErr, ok := err.(*store.LimitError); ok {
+		gluttony(w, limitErr)
 		return
 	}
 
-	// Make sure number of existing purchases + new purchases does not exceed 3
-	if purchases != nil {
-		numPurchases, err := redis.Int(purchases, nil)
-		if err != nil {
-			errMsg := fmt.Sprintf("error: could not determine number of purchases: user=%q, value=%v", username, purchases)
-			log.Println(errMsg)
-			encodeError(w, errMsg)
-			return
-		}
-
-		if numPurchases+wantedSlices > 3 {
-			log.Printf("debug: gluttony: wanted=%d, numPurchasedExisting=%d\n\n", wantedSlices, numPurchases)
-			gluttony(w)
-			return
-		}
-	}
-
-	// Check for remaining slices
-	remainingSlices, err := redis.Int(conn.Do("GET", slicesKey))
-	if err != nil {
-		redisError(w, err)
-		return
-	}
-
-	// see if we have enough slices to sell to the user
-	if remainingSlices == 0 {
+	switch err {
+	case nil:
+		orderCreated(w, r, order)
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+	case store.ErrSoldOut:
 		gone(w, nil)
-		return
-	}
-
-	if wantedSlices > remainingSlices {
-		log.Printf("debug: gone: remaining=%d, wanted=%d\n", remainingSlices, wantedSlices)
+	case store.ErrNotEnoughSlices:
 		gone(w, "not enough remaining slices")
-		return
+	case store.ErrWrongMaths:
+		wrongMaths(w)
+	default:
+		storeError(w, err)
 	}
+}
 
-	// Check the maths
-	pricePerSlice, err := redis.Float64(conn.Do("HGET", hkey, "price"))
-	if err != nil {
-		redisError(w, err)
-		return
-	}
+// refundPie is the endpoint that allows users to give back slices they
+// purchased. All of the user's slices are refunded unless slices is specified.
+func refundPie(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	pieID := params["id"]
+	username := r.URL.Query().Get("username")
+	slicesStr := r.URL.Query().Get("slices")
 
-	if int(pricePerSlice*float64(wantedSlices)*100) != int(amount*100) {
-		log.Printf("debug: wrong math: amount=%f, calculated=%f\n", amount, pricePerSlice*float64(wantedSlices))
-		wrongMaths(w)
+	if username == "" {
+		encodeBadRequest(w, "error: missing information: missing username")
 		return
 	}
 
-	// ------------------------------------------------------------------------
-	// Attempt to PURCHASE
-	// ------------------------------------------------------------------------
-	// 1. Lock (watch)
-	// 2. Check all params to make sure the user can still update
-	// 3. If all goes well, update
-	// ------------------------------------------------------------------------
-	userAvailableKey := fmt.Sprintf(UserAvailableKey, username)
-	userUnavailableKey := fmt.Sprintf(UserUnavailableKey, username)
-	var transactionError error
-	for i := 0; i < 5; i++ {
-		// TODO: sleep maybe for exponential backoff?
-		_, err := conn.Do("WATCH", slicesKey, piePurchasersKey, purchasesKey, userAvailableKey, PiesAvailableKey)
-		if err != nil {
-			transactionError = err
-			continue
-		}
-
-		remainingSlices, err := redis.Int(conn.Do("GET", slicesKey))
-		if err != nil {
-			transactionError = err
-			continue
-		}
-
-		purchasedSlices := 0
-		nSlices, err := conn.Do("GET", purchasesKey)
-		if err != nil {
-			transactionError = err
-			continue
-		}
-
-		if nSlices != nil {
-			purchasedSlices, err = redis.Int(nSlices, nil)
-			if err != nil {
-				log.Printf("error: could not type assert num purchased slices: err=%q\n", err)
-				encodeError(w, "error: could not complete purchase")
-				return
-			}
-		}
-
-		// Make sure we are within our limit
-		if (purchasedSlices + wantedSlices) > 3 {
-			conn.Do("UNWATCH")
-			gluttony(w)
-			return
-		}
-
-		// Make sure we actually have enough slices
-		if remainingSlices < wantedSlices {
-			conn.Do("UNWATCH")
-			gone(w, nil)
-			return
-		}
-
-		// Perform the transaction
-		conn.Send("MULTI")
-		conn.Send("DECRBY", slicesKey, wantedSlices)
-		conn.Send("INCRBY", purchasesKey, wantedSlices)
-		conn.Send("SADD", piePurchasersKey, username)
-
-		// Check to see if the pie is still available?
-		if (remainingSlices - wantedSlices) == 0 {
-			conn.Send("SREM", PiesAvailableKey, pieID)
-		}
-
-		// Check to see if the user can still buy more
-		if (purchasedSlices + wantedSlices) == 3 {
-			conn.Send("SADD", userUnavailableKey, pieID)
-			conn.Send("SDIFFSTORE", userAvailableKey, PiesAvailableKey, userUnavailableKey)
-		}
-
-		reply, err := conn.Do("EXEC")
-		if err != nil {
-			transactionError = err
-			continue
-		}
-
-		if reply != nil {
-			log.Printf("debug: success purchase: user=%q, wanted=%d, remaining=%d, newRemaining=%d\n",
-				username, wantedSlices, remainingSlices, remainingSlices-wantedSlices)
-			w.WriteHeader(http.StatusCreated)
+	slices := 0
+	if slicesStr != "" {
+		var err error
+		slices, err = strconv.Atoi(slicesStr)
+		if err != nil || slices < 1 {
+			encodeBadRequest(w, "error: slices is not a positive integer")
 			return
 		}
 	}
 
-	if transactionError != nil {
-		redisError(w, transactionError)
-		return
+	refund, err := pieStore.Refund(pieID, username, slices)
+	switch err {
+	case nil:
+		encodeJSON(w, refund, nil)
+	case store.ErrNotFound:
+		http.NotFound(w, r)
+	case store.ErrNotPurchased:
+		encodeBadRequest(w, "error: cannot refund more slices than were purchased")
+	default:
+		storeError(w, err)
 	}
-
-	// failed
-	encodeError(w, "could not perform purchase")
 }
 
-// Gluttony returns the gluttony response
-func gluttony(w http.ResponseWriter) {
+// Gluttony returns the gluttony response along with the limit that was hit
+// and the number of slices the user can still buy
+func gluttony(w http.ResponseWriter, limitErr *store.LimitError) {
 	w.WriteHeader(http.StatusTooManyRequests)
 	encoder := json.NewEncoder(w)
-	err := errorResponse{"Gluttony is discouraged."}
+	err := limitResponse{
+		Error:     "Gluttony is discouraged.",
+		Limit:     limitErr.Limit,
+		Max:       limitErr.Max,
+		Remaining: limitErr.Remaining,
+	}
 	encoder.Encode(err)
 }
 
@@ -554,22 +305,6 @@ func wrongMaths(w http.ResponseWriter) {
 	encoder.Encode(err)
 }
 
-func recommend(w http.ResponseWriter, r *http.Request, p *pie.RecommendPie) {
-	encoder := json.NewEncoder(w)
-	resp := struct {
-		PieURL string `json:"pie_url"`
-	}{"http://" + r.Host + "/pies/" + strconv.FormatUint(p.ID, 10)}
-	encoder.Encode(resp)
-}
-
-// noReommended pies for you sir
-func noRecommended(w http.ResponseWriter) {
-	encoder := json.NewEncoder(w)
-	err := errorResponse{"Sorry we don’t have what you’re looking for.  Come back early tomorrow before the crowds come from the best pie selection."}
-	w.WriteHeader(http.StatusNotFound)
-	encoder.Encode(err)
-}
-
 // ----------------------------------------------------------------------------
 // API Response Helpers
 // ----------------------------------------------------------------------------
@@ -578,6 +313,13 @@ type errorResponse struct {
 	Error string `json:"error"`
 }
 
+type limitResponse struct {
+	Error     string `json:"error"`
+	Limit     string `json:"limit"`
+	Max       int    `json:"max"`
+	Remaining int    `json:"remaining"`
+}
+
 type errorsResponse struct {
 	Errors []string `json:"errors"`
 }
@@ -595,6 +337,17 @@ func encodeJSON(w http.ResponseWriter, data interface{}, statusCode interface{})
 	encoder.Encode(data)
 }
 
+// encodeCSV is a helper that writes out the records as a CSV file
+func encodeCSV(w http.ResponseWriter, filename string, records [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	writer := csv.NewWriter(w)
+	writer.WriteAll(records)
+	if err := writer.Error(); err != nil {
+		log.Printf("error: could not write csv: err=%q\n", err)
+	}
+}
+
 // encodeError is a helper that takes an array of error messages and
 // serializes into an errors JSON response while setting the http status code
 // to 500 (internal status errorr)
@@ -616,9 +369,9 @@ func encodeBadRequest(w http.ResponseWriter, msgs ...string) {
 	encoder.Encode(errors)
 }
 
-// redisError is a helper function that reports all redis errors
-func redisError(w http.ResponseWriter, e error) {
-	errMsg := fmt.Sprintf("error: redis connection error: err=%q\n", e)
+// storeError is a helper function that reports all errors from the pie store
+func storeError(w http.ResponseWriter, e error) {
+	errMsg := fmt.Sprintf("error: store error: err=%q\n", e)
 	log.Println(errMsg)
 	encodeError(w, errMsg)
 }
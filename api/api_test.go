@@ -0,0 +1,329 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/davinche/gpies/config"
+	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/store"
+	"github.com/dimfeld/httptreemux"
+)
+
+// newTestRouter serves the API from a MemoryStore with an apple pie of 10
+// slices at 1.50 and a pecan pie of 2 slices at 2.35
+func newTestRouter(t *testing.T) (*httptreemux.TreeMux, *store.MemoryStore) {
+	s := store.NewMemoryStore(store.Limits{PerPie: 3})
+	err := s.Load(pie.Pies{
+		{ID: 1, Name: "Apple Pie", ImageURL: "http://example.com/apple.jpg", Price: 150, Slices: 10, Labels: []string{"sweet"}},
+		{ID: 2, Name: "Pecan Pie", ImageURL: "http://example.com/pecan.jpg", Price: 235, Slices: 2, Labels: []string{"sweet", "nutty"}},
+	})
+	if err != nil {
+		t.Fatalf("could not load pies: %v", err)
+	}
+
+	router := httptreemux.New()
+	Handle("/", router, s, nil)
+	return router, s
+}
+
+// serve performs a request against the router
+func serve(router http.Handler, method, url, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// decode reads the JSON body of a response
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("could not decode %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestPurchasePie(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		code  int
+		total pie.Cents
+	}{
+		{"one slice", "/pie/1/purchases?username=bob&amount=1.50", http.StatusCreated, 150},
+		{"several slices", "/pie/2/purchases?username=bob&amount=4.70&slices=2", http.StatusCreated, 470},
+		{"missing username", "/pie/1/purchases?amount=1.50", http.StatusBadRequest, 0},
+		{"amount is not a decimal", "/pie/1/purchases?username=bob&amount=abc", http.StatusBadRequest, 0},
+		{"negative slices", "/pie/1/purchases?username=bob&amount=-1.50&slices=-1", http.StatusBadRequest, 0},
+		{"no slices", "/pie/1/purchases?username=bob&amount=0&slices=0", http.StatusBadRequest, 0},
+		{"wrong maths", "/pie/1/purchases?username=bob&amount=1.49", http.StatusPaymentRequired, 0},
+		{"gluttony", "/pie/1/purchases?username=bob&amount=6.00&slices=4", http.StatusTooManyRequests, 0},
+		{"not enough slices", "/pie/2/purchases?username=bob&amount=7.05&slices=3", http.StatusGone, 0},
+		{"unknown pie", "/pie/3/purchases?username=bob&amount=1.50", http.StatusNotFound, 0},
+	}
+
+	for _, test := range tests {
+		router, _ := newTestRouter(t)
+		w := serve(router, "POST", test.url, "", nil)
+		if w.Code != test.code {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.code, w.Body)
+			continue
+		}
+		if w.Code != http.StatusCreated {
+			continue
+		}
+
+		order := &pie.Order{}
+		decode(t, w, order)
+		if order.Total != test.total {
+			t.Errorf("%s: got total %s, want %s", test.name, order.Total, test.total)
+		}
+		if location := w.Header().Get("Location"); location != "http://example.com/orders/"+order.ID {
+			t.Errorf("%s: got location %q", test.name, location)
+		}
+	}
+}
+
+func TestPurchaseExactAmount(t *testing.T) {
+	router, s := newTestRouter(t)
+	_, err := s.Restock("2", 3)
+	if err != nil {
+		t.Fatalf("could not restock: %v", err)
+	}
+
+	w := serve(router, "POST", "/pie/2/purchases?username=bob&amount=7.05&slices=3", "", nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+
+	order := &pie.Order{}
+	decode(t, w, order)
+	if order.Total != 705 || order.Lines[0].UnitPrice != 235 {
+		t.Errorf("got order %+v, want 3 slices at 2.35 for 7.05", order)
+	}
+}
+
+func TestCreateOrder(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	w := serve(router, "POST", "/orders", `{"username": "bob", "amount": 3.85, "lines": [{"pie_id": 1, "slices": 1}, {"pie_id": 2, "slices": 1}]}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	order := &pie.Order{}
+	decode(t, w, order)
+
+	w = serve(router, "GET", "/orders/"+order.ID+".json", "", nil)
+	saved := &pie.Order{}
+	decode(t, w, saved)
+	if saved.Total != 385 || len(saved.Lines) != 2 {
+		t.Errorf("got order %+v, want 2 lines for 3.85", saved)
+	}
+
+	w = serve(router, "POST", "/orders", `{"username": "bob", "amount": -1.50, "lines": [{"pie_id": 1, "slices": -1}]}`, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d for negative slices", w.Code, http.StatusBadRequest)
+	}
+
+	w = serve(router, "POST", "/orders", `{"username": "ann", "amount": 5.20, "lines": [{"pie_id": 1, "slices": 1}, {"pie_id": 2, "slices": 2}]}`, nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d, want %d when a line is rejected", w.Code, http.StatusConflict)
+	}
+}
+
+func TestRefundPie(t *testing.T) {
+	router, s := newTestRouter(t)
+	serve(router, "POST", "/pie/1/purchases?username=bob&amount=3.00&slices=2", "", nil)
+
+	// The price changes after the purchase
+	err := s.EditPie(1, func(old *pie.Pie) (*pie.Pie, error) {
+		old.Price = 500
+		return old, nil
+	})
+	if err != nil {
+		t.Fatalf("could not edit pie: %v", err)
+	}
+
+	w := serve(router, "DELETE", "/pie/1/purchases?username=bob&slices=1", "", nil)
+	refund := &pie.Refund{}
+	decode(t, w, refund)
+	if refund.Slices != 1 || refund.Amount != 150 {
+		t.Errorf("got refund %+v, want 1 slice for 1.50", refund)
+	}
+
+	w = serve(router, "DELETE", "/pie/1/purchases?username=bob&slices=2", "", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d when refunding too many slices", w.Code, http.StatusBadRequest)
+	}
+
+	w = serve(router, "GET", "/users/bob/purchases.json", "", nil)
+	history := &pie.History{}
+	decode(t, w, history)
+	if len(history.Purchases) != 1 || history.Purchases[0].Slices != 1 || history.Purchases[0].Refunded != 1 || history.Purchases[0].Amount != 150 {
+		t.Errorf("got purchases %+v, want 1 slice kept and 1 refunded", history.Purchases)
+	}
+}
+
+func TestIdempotentPurchase(t *testing.T) {
+	config.Config.IdempotencyWindow = 60
+	router, _ := newTestRouter(t)
+	header := map[string]string{"Idempotency-Key": "key"}
+
+	first := serve(router, "POST", "/pie/1/purchases?username=bob&amount=1.50", "", header)
+	second := serve(router, "POST", "/pie/1/purchases?username=bob&amount=1.50", "", header)
+	if first.Code != http.StatusCreated || second.Code != http.StatusCreated {
+		t.Fatalf("got statuses %d and %d, want %d", first.Code, second.Code, http.StatusCreated)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" || second.Body.String() != first.Body.String() {
+		t.Errorf("got %q, want the first response replayed", second.Body)
+	}
+	if second.Header().Get("Location") == "" || second.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("got location %q, want %q", second.Header().Get("Location"), first.Header().Get("Location"))
+	}
+
+	w := serve(router, "GET", "/pie/1.json", "", nil)
+	details := &pie.Details{}
+	decode(t, w, details)
+	if details.RemainingSlices != 9 {
+		t.Errorf("got %d remaining slices, want 9", details.RemainingSlices)
+	}
+
+	w = serve(router, "POST", "/pie/2/purchases?username=bob&amount=2.35", "", header)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d, want %d for a different request", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	// Another user sending the same key makes another purchase
+	w = serve(router, "POST", "/pie/1/purchases?username=ann&amount=1.50", "", header)
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" || w.Body.String() == first.Body.String() {
+		t.Errorf("got status %d and %q, want a purchase of its own", w.Code, w.Body)
+	}
+}
+
+func TestIdempotencyLeaseExtended(t *testing.T) {
+	config.Config.IdempotencyWindow = 60
+	newTestRouter(t)
+	idempotencyLease = 30 * time.Millisecond
+	defer func() { idempotencyLease = time.Minute }()
+
+	// The request takes longer than the lease
+	var calls int32
+	slow := idempotent(func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	})
+	run := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/slow?username=bob", nil)
+		r.Header.Set("Idempotency-Key", "key")
+		w := httptest.NewRecorder()
+		slow(w, r, nil)
+		return w
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- run() }()
+	time.Sleep(50 * time.Millisecond)
+	second := run()
+	<-first
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("got the request performed %d times, want once", n)
+	}
+	if second.Code != http.StatusCreated || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("got status %d, want the first response replayed", second.Code)
+	}
+}
+
+func TestAdminPies(t *testing.T) {
+	config.Config.AdminToken = "token"
+	router, _ := newTestRouter(t)
+	auth := map[string]string{"Authorization": "Bearer token"}
+	body := `{"id": 3, "name": "Cherry Pie", "image_url": "http://example.com/cherry.jpg", "price_per_slice": 3.00, "slices": 4, "labels": ["sweet", "fruity"]}`
+
+	w := serve(router, "POST", "/admin/pies", body, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d without the token", w.Code, http.StatusUnauthorized)
+	}
+
+	w = serve(router, "POST", "/admin/pies", body, auth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+
+	w = serve(router, "POST", "/admin/pies", body, auth)
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d, want %d for an existing pie", w.Code, http.StatusConflict)
+	}
+
+	w = serve(router, "GET", "/pies/recommend?username=bob&labels=fruity", "", nil)
+	resp := &recommendResponse{}
+	decode(t, w, resp)
+	if len(resp.Pies) != 1 || resp.Pies[0].ID != 3 {
+		t.Errorf("got pies %+v, want the created pie", resp.Pies)
+	}
+
+	w = serve(router, "PATCH", "/admin/pies/3", `{"price_per_slice": 3.50}`, auth)
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	w = serve(router, "DELETE", "/admin/pies/3", "", auth)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	w = serve(router, "GET", "/pie/3.json", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d for a retired pie", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRestockPie(t *testing.T) {
+	config.Config.AdminToken = "token"
+	router, _ := newTestRouter(t)
+	auth := map[string]string{"Authorization": "Bearer token"}
+
+	w := serve(router, "POST", "/admin/pies/2/restock", `{"slices": 3}`, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d without the token", w.Code, http.StatusUnauthorized)
+	}
+
+	w = serve(router, "POST", "/admin/pies/2/restock", `{"slices": 3}`, auth)
+	restock := &restockResponse{}
+	decode(t, w, restock)
+	if restock.RemainingSlices != 5 {
+		t.Errorf("got %d remaining slices, want 5", restock.RemainingSlices)
+	}
+
+	w = serve(router, "POST", "/admin/pies/3/restock", `{"slices": 3}`, auth)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d for an unknown pie", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestGetLabels(t *testing.T) {
+	router, _ := newTestRouter(t)
+	serve(router, "POST", "/pie/2/purchases?username=bob&amount=4.70&slices=2", "", nil)
+
+	w := serve(router, "GET", "/labels.json", "", nil)
+	resp := &labelsResponse{}
+	decode(t, w, resp)
+
+	want := []pie.Label{{Name: "nutty", Pies: 1, InStock: 0}, {Name: "sweet", Pies: 2, InStock: 1}}
+	if len(resp.Labels) != len(want) {
+		t.Fatalf("got labels %+v, want %+v", resp.Labels, want)
+	}
+	for i, l := range want {
+		if *resp.Labels[i] != l {
+			t.Errorf("got label %+v, want %+v", *resp.Labels[i], l)
+		}
+	}
+}
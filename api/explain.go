@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/recommender"
+	"github.com/davinche/gpies/store"
+)
+
+// explanation tells a user why pies were or were not recommended to them
+type explanation struct {
+	Filters  *filtersExplanation     `json:"filters"`
+	Matched  []*matchExplanation     `json:"matched"`
+	Excluded []*exclusionExplanation `json:"excluded"`
+	Ranking  string                  `json:"ranking,omitempty"`
+}
+
+// filtersExplanation lists the filters of the request
+type filtersExplanation struct {
+	Labels     []string   `json:"labels"`
+	LabelsAny  []string   `json:"labels_any"`
+	LabelsNone []string   `json:"labels_none"`
+	MaxPrice   *pie.Cents `json:"max_price,omitempty"`
+}
+
+// matchExplanation lists the labels of a recommended pie that matched the
+// label filters
+type matchExplanation struct {
+	ID     uint64   `json:"id"`
+	Name   string   `json:"name"`
+	Labels []string `json:"labels"`
+}
+
+// exclusionExplanation is the reason a pie was not recommended
+type exclusionExplanation struct {
+	ID     uint64 `json:"id"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// explain builds the explanation of the recommended pies.
+// result is nil when there are no pies to recommend.
+func explain(params recommendParams, listOfPies pie.RecommendPies, result *recommender.Result) (*explanation, error) {
+	pies, err := pieStore.Pies(store.LabelQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := pieStore.History(params.username)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := map[uint64]int{}
+	for _, a := range history.Allowances {
+		remaining[a.PieID] = a.Remaining
+	}
+
+	recommended := map[uint64]bool{}
+	for _, p := range listOfPies {
+		recommended[p.ID] = true
+	}
+
+	e := &explanation{
+		Filters: &filtersExplanation{
+			Labels:     params.labels.All,
+			LabelsAny:  params.labels.Any,
+			LabelsNone: params.labels.None,
+			MaxPrice:   params.maxPrice,
+		},
+		Matched:  []*matchExplanation{},
+		Excluded: []*exclusionExplanation{},
+	}
+
+	for _, p := range pies {
+		if recommended[p.ID] {
+			e.Matched = append(e.Matched, &matchExplanation{p.ID, p.Name, matchedLabels(p, params.labels)})
+			continue
+		}
+
+		var reason string
+		switch {
+		case !params.labels.Matches(p.Labels):
+			reason = "does not match the label filters"
+		case p.Slices == 0:
+			reason = "sold out"
+		case remaining[p.ID] == 0:
+			reason = "user limit reached"
+		case params.maxPrice != nil && p.Price > *params.maxPrice:
+			reason = fmt.Sprintf("over budget: $%s per slice is more than $%s", p.Price, params.maxPrice)
+		default:
+			reason = "not available"
+		}
+		e.Excluded = append(e.Excluded, &exclusionExplanation{p.ID, p.Name, reason})
+	}
+
+	if result != nil {
+		e.Ranking = fmt.Sprintf("%s strategy: %s", result.Strategy, result.Rule)
+	}
+	return e, nil
+}
+
+// matchedLabels returns the labels of the pie that the label query asked for
+func matchedLabels(p *pie.Pie, query store.LabelQuery) []string {
+	wanted := map[string]bool{}
+	for _, l := range query.All {
+		wanted[l] = true
+	}
+	for _, l := range query.Any {
+		wanted[l] = true
+	}
+
+	labels := []string{}
+	for _, l := range p.Labels {
+		if wanted[l] {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The formats a resource can be returned in
+const (
+	formatHTML = "html"
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// mediaTypes maps the media types of the Accept header to formats
+var mediaTypes = map[string]string{
+	"text/html":        formatHTML,
+	"application/json": formatJSON,
+	"text/csv":         formatCSV,
+}
+
+// negotiateFormat picks the format of the response. A ".json" or ".csv"
+// suffix of the resource wins over the Accept header and HTML is returned
+// when neither asks for one of the supported formats. Of the media types of
+// the Accept header, the one with the highest q value wins, the first one
+// among equals, and a q value of 0 rules a media type out.
+// It returns the resource without its suffix.
+func negotiateFormat(w http.ResponseWriter, r *http.Request, resource string, supported ...string) (string, string) {
+	w.Header().Add("Vary", "Accept")
+
+	for _, format := range supported {
+		if strings.HasSuffix(resource, "."+format) {
+			return strings.TrimSuffix(resource, "."+format), format
+		}
+	}
+
+	// Take the media type of the Accept header we support with the highest q
+	best, bestQuality := formatHTML, 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+
+		format, ok := mediaTypes[mediaType]
+		if !ok || !isSupported(format, supported) {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+	return resource, best
+}
+
+// isSupported tells if a format can be returned, HTML always can
+func isSupported(format string, supported []string) bool {
+	if format == formatHTML {
+		return true
+	}
+	for _, s := range supported {
+		if s == format {
+			return true
+		}
+	}
+	return false
+}
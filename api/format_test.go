@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		resource string
+		accept   string
+		format   string
+	}{
+		{"pies", "", formatHTML},
+		{"pies.json", "text/csv", formatJSON},
+		{"pies.csv", "", formatCSV},
+		{"pies", "application/json", formatJSON},
+		{"pies", "text/csv, application/json", formatCSV},
+		{"pies", "text/csv;q=0, application/json", formatJSON},
+		{"pies", "text/csv;q=0.5, application/json;q=0.8", formatJSON},
+		{"pies", "text/html;q=0.9, text/csv", formatCSV},
+		{"pies", "text/html, text/csv", formatHTML},
+		{"pies", "text/csv;q=0", formatHTML},
+		{"pies", "text/csv;q=abc, application/json;q=0.1", formatJSON},
+		{"pies", "image/png, */*", formatHTML},
+		{"pie/1", "text/csv", formatHTML},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/"+test.resource, nil)
+		r.Header.Set("Accept", test.accept)
+		supported := []string{formatJSON, formatCSV}
+		if test.resource == "pie/1" {
+			supported = []string{formatJSON}
+		}
+
+		_, format := negotiateFormat(httptest.NewRecorder(), r, test.resource, supported...)
+		if format != test.format {
+			t.Errorf("%s with Accept %q: got %s, want %s", test.resource, test.accept, format, test.format)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/pies.csv", nil)
+	resource, _ := negotiateFormat(httptest.NewRecorder(), r, "pies.csv", formatJSON, formatCSV)
+	if resource != "pies" {
+		t.Errorf("got resource %s, want the suffix removed", resource)
+	}
+}
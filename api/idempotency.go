@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/davinche/gpies/config"
+	"github.com/davinche/gpies/store"
+	"github.com/dimfeld/httptreemux"
+)
+
+// How often and for how long a repeated request waits for the first request
+// with the same Idempotency-Key to finish
+const idempotencyPollInterval = 50 * time.Millisecond
+const idempotencyWaitTimeout = 10 * time.Second
+
+// idempotencyLease is how long a key is claimed while its request is in
+// progress, so that the key can be used again when the process stops before
+// the response is saved. The lease is extended for as long as the request
+// takes.
+var idempotencyLease = time.Minute
+
+// recorder captures the response written by a handler so it can be replayed
+type recorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(code int) {
+	r.statusCode = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent wraps a handler so that requests sending an Idempotency-Key
+// header are only performed once. Repeated requests with the same key from
+// the same user get the status code, headers and body of the first request.
+func idempotent(h httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			h(w, r, params)
+			return
+		}
+
+		// The body is part of the request so a key can not be reused
+		// for a different order
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			encodeBadRequest(w, "error: could not read request body")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		claim, err := newClaim()
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+
+		username := requestUsername(r, body)
+		window := time.Duration(config.Config.IdempotencyWindow) * time.Second
+		request := fmt.Sprintf("%s %s %x", r.Method, r.URL, sha256.Sum256(body))
+		deadline := time.Now().Add(idempotencyWaitTimeout)
+		for {
+			saved, claimed, err := pieStore.ClaimIdempotencyKey(username, key, request, claim, idempotencyLease)
+			if err != nil {
+				storeError(w, err)
+				return
+			}
+
+			if claimed {
+				break
+			}
+
+			if saved.Request != request {
+				w.Header().Add("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(errorResponse{"Idempotency-Key was already used for a different request."})
+				return
+			}
+
+			// Replay the response of the first request
+			if saved.StatusCode != 0 {
+				log.Printf("debug: idempotent replay: key=%q, status=%d\n", key, saved.StatusCode)
+				for name, values := range saved.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(saved.StatusCode)
+				w.Write(saved.Body)
+				return
+			}
+
+			// The first request is still in progress
+			if time.Now().After(deadline) {
+				w.Header().Add("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(errorResponse{"A request with this Idempotency-Key is still in progress."})
+				return
+			}
+			time.Sleep(idempotencyPollInterval)
+		}
+
+		// A retry must not perform the request again while it takes longer
+		// than the lease
+		done := make(chan struct{})
+		go holdClaim(username, key, claim, idempotencyLease, done)
+
+		rec := &recorder{ResponseWriter: w}
+		h(rec, r, params)
+		close(done)
+		if rec.statusCode == 0 {
+			rec.statusCode = http.StatusOK
+		}
+
+		// Server errors are not saved so the request can be retried
+		if rec.statusCode >= http.StatusInternalServerError {
+			err := pieStore.ReleaseIdempotencyKey(username, key)
+			if err != nil {
+				log.Printf("error: could not release idempotency key: key=%q, err=%q\n", key, err)
+			}
+			return
+		}
+
+		err = pieStore.SaveIdempotentResponse(username, key, &store.SavedResponse{
+			Request:    request,
+			StatusCode: rec.statusCode,
+			Header:     rec.Header().Clone(),
+			Body:       rec.body.Bytes(),
+		}, window)
+		if err != nil {
+			log.Printf("error: could not save idempotent response: key=%q, err=%q\n", key, err)
+		}
+	}
+}
+
+// requestUsername is the user a request is made for, from the query of a
+// purchase or the body of an order
+func requestUsername(r *http.Request, body []byte) string {
+	if username := r.URL.Query().Get("username"); username != "" {
+		return username
+	}
+
+	req := struct {
+		Username string `json:"username"`
+	}{}
+	json.Unmarshal(body, &req)
+	return req.Username
+}
+
+// newClaim identifies an attempt to perform a request
+func newClaim() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// holdClaim extends the lease of a claimed key until done is closed
+func holdClaim(username, key, claim string, lease time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			held, err := pieStore.ExtendIdempotencyKey(username, key, claim, lease)
+			if err != nil {
+				log.Printf("error: could not extend idempotency key: key=%q, err=%q\n", key, err)
+				continue
+			}
+			if !held {
+				log.Printf("error: idempotency key is no longer claimed: key=%q\n", key)
+				return
+			}
+		}
+	}
+}
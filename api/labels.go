@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/davinche/gpies/pie"
+)
+
+// labelsResponse is the JSON list of labels
+type labelsResponse struct {
+	Labels []*pie.Label `json:"labels"`
+}
+
+// getLabels returns every label with the number of pies carrying it and how
+// many of them are in stock
+func getLabels(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	_, format := negotiateFormat(w, r, r.URL.Path, formatJSON)
+
+	labels, err := pieStore.Labels()
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+
+	// showing json? Or rendering template
+	if format == formatJSON {
+		encodeJSON(w, labelsResponse{labels}, nil)
+		return
+	}
+	LabelsList.Execute(w, labels)
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/store"
+)
+
+// orderRequest is the body of a request to create an order
+type orderRequest struct {
+	Username string           `json:"username"`
+	Lines    []*pie.OrderLine `json:"lines"`
+	Amount   *pie.Cents       `json:"amount"`
+}
+
+// lineErrorResponse is the reason a line of an order was rejected
+type lineErrorResponse struct {
+	PieID     uint64 `json:"pie_id"`
+	Error     string `json:"error"`
+	Limit     string `json:"limit,omitempty"`
+	Max       int    `json:"max,omitempty"`
+	Remaining *int   `json:"remaining,omitempty"`
+}
+
+// orderRejectedResponse lists why an order was rejected
+type orderRejectedResponse struct {
+	Errors []string             `json:"errors"`
+	Lines  []*lineErrorResponse `json:"lines"`
+}
+
+// createOrder is the endpoint that allows users to purchase slices of several
+// pies at once. Either every line of the order is purchased or none is.
+func createOrder(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	req := orderRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		encodeBadRequest(w, fmt.Sprintf("error: could not decode order: err=%q", err))
+		return
+	}
+
+	// make sure all data is there
+	errors := []string{}
+	errorFmt := "error: missing information: %s"
+	if req.Username == "" {
+		errors = append(errors, fmt.Sprintf(errorFmt, "missing username"))
+	}
+
+	if req.Amount == nil {
+		errors = append(errors, fmt.Sprintf(errorFmt, "missing amount"))
+	}
+
+	if len(req.Lines) == 0 {
+		errors = append(errors, fmt.Sprintf(errorFmt, "missing lines"))
+	}
+
+	for i, l := range req.Lines {
+		if l.Slices < 1 {
+			errors = append(errors, fmt.Sprintf("error: line %d: slices is not a positive integer", i))
+		}
+	}
+
+	if len(errors) > 0 {
+		encodeBadRequest(w, errors...)
+		return
+	}
+
+	order, err := pieStore.Checkout(req.Username, req.Lines, *req.Amount)
+	if checkoutErr, ok := err.(*store.CheckoutError); ok {
+		orderRejected(w, checkoutErr)
+		return
+	}
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	orderCreated(w, r, order)
+}
+
+// getOrder returns the record of an order as a receipt
+func getOrder(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	orderID := params["id"]
+	isJSON := false
+	if strings.HasSuffix(orderID, ".json") {
+		isJSON = true
+		orderID = orderID[:strings.LastIndex(orderID, ".")]
+	}
+
+	order, err := pieStore.Order(orderID)
+	if err == store.ErrOrderNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+
+	// showing json? Or rendering template
+	if isJSON {
+		encodeJSON(w, order, nil)
+		return
+	}
+	OrderReceipt.Execute(w, order)
+}
+
+// orderCreated returns the order along with where its receipt can be found
+func orderCreated(w http.ResponseWriter, r *http.Request, order *pie.Order) {
+	w.Header().Set("Location", "http://"+r.Host+"/orders/"+order.ID)
+	encodeJSON(w, order, http.StatusCreated)
+}
+
+// orderRejected returns the reasons an order was rejected.
+// Orders with rejected lines are a conflict with the stock or the limits of
+// the user, otherwise the status matches the one of a single purchase.
+func orderRejected(w http.ResponseWriter, checkoutErr *store.CheckoutError) {
+	resp := orderRejectedResponse{
+		Errors: []string{},
+		Lines:  []*lineErrorResponse{},
+	}
+
+	code := http.StatusConflict
+	if checkoutErr.Err != nil {
+		resp.Errors = append(resp.Errors, purchaseErrorMessage(checkoutErr.Err))
+		if checkoutErr.Err == store.ErrWrongMaths {
+			code = http.StatusPaymentRequired
+		} else {
+			code = http.StatusTooManyRequests
+		}
+	}
+
+	for _, l := range checkoutErr.Lines {
+		lineErr := &lineErrorResponse{
+			PieID: l.PieID,
+			Error: purchaseErrorMessage(l.Err),
+		}
+		if limitErr, ok := l.Err.(*store.LimitError); ok {
+			lineErr.Limit = limitErr.Limit
+			lineErr.Max = limitErr.Max
+			lineErr.Remaining = &limitErr.Remaining
+		}
+		resp.Lines = append(resp.Lines, lineErr)
+	}
+
+	if len(resp.Lines) > 0 {
+		code = http.StatusConflict
+	}
+	encodeJSON(w, resp, code)
+}
+
+// purchaseErrorMessage is the message shown to the user for an error
+// returned when purchasing
+func purchaseErrorMessage(err error) string {
+	if _, ok := err.(*store.LimitError); ok {
+		return "Gluttony is discouraged."
+	}
+
+	switch err {
+	case store.ErrNotFound:
+		return "No such pie."
+	case store.ErrSoldOut:
+		return "No more of that pie. Try something else."
+	case store.ErrNotEnoughSlices:
+		return "not enough remaining slices"
+	case store.ErrWrongMaths:
+		return "You did math wrong."
+	}
+	return err.Error()
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/store"
+)
+
+// The default and maximum number of pies in a page of the list
+const (
+	defaultPieListLimit = 20
+	maxPieListLimit     = 100
+)
+
+// pieListSorts are the orders the list of pies can be sorted in
+var pieListSorts = map[string]func(a, b *pie.Pie) bool{
+	"id":               func(a, b *pie.Pie) bool { return a.ID < b.ID },
+	"price":            func(a, b *pie.Pie) bool { return a.Price < b.Price },
+	"name":             func(a, b *pie.Pie) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+	"remaining_slices": func(a, b *pie.Pie) bool { return a.Slices < b.Slices },
+}
+
+// pieListParams are the filters, order and page of a request for the list
+// of pies
+type pieListParams struct {
+	labels   store.LabelQuery
+	minPrice *pie.Cents
+	maxPrice *pie.Cents
+	inStock  bool
+	name     string
+	sort     string
+	desc     bool
+	page     int
+	limit    int
+}
+
+// pieListPage is a page of the list of pies along with links to the
+// previous and next pages
+type pieListPage struct {
+	Pies  pie.Pies
+	Total int
+	Page  int
+	Limit int
+	Pages int
+	Prev  string
+	Next  string
+}
+
+// pieListResponse is the JSON list of pies
+type pieListResponse struct {
+	Pies  []*pieListItem `json:"pies"`
+	Total int            `json:"total"`
+	Page  int            `json:"page"`
+	Limit int            `json:"limit"`
+	Pages int            `json:"pages"`
+	Prev  string         `json:"prev,omitempty"`
+	Next  string         `json:"next,omitempty"`
+}
+
+// pieListItem is a pie in the JSON list of pies
+type pieListItem struct {
+	ID              uint64    `json:"id"`
+	Name            string    `json:"name"`
+	ImageURL        string    `json:"image_url"`
+	Price           pie.Cents `json:"price_per_slice"`
+	RemainingSlices int       `json:"remaining_slices"`
+	Labels          []string  `json:"labels"`
+	Permalink       string    `json:"permalink"`
+}
+
+// pieListColumns are the columns of the CSV list of pies
+var pieListColumns = []string{"id", "name", "image_url", "price_per_slice", "remaining_slices", "labels", "permalink"}
+
+// getPieListParams validates the options of a request for the list of pies
+func getPieListParams(r *http.Request) (params pieListParams, errors []string) {
+	params.labels = getLabelQuery(r)
+	params.name = strings.TrimSpace(r.FormValue("name"))
+	params.sort = "id"
+	params.page = 1
+	params.limit = defaultPieListLimit
+
+	if minPriceStr := r.FormValue("min_price"); minPriceStr != "" {
+		minPrice, err := pie.ParseCents(minPriceStr)
+		if err != nil || minPrice < 0 {
+			errors = append(errors, "error: min_price is not a positive decimal")
+		}
+		params.minPrice = &minPrice
+	}
+
+	if maxPriceStr := r.FormValue("max_price"); maxPriceStr != "" {
+		maxPrice, err := pie.ParseCents(maxPriceStr)
+		if err != nil || maxPrice < 0 {
+			errors = append(errors, "error: max_price is not a positive decimal")
+		}
+		params.maxPrice = &maxPrice
+	}
+
+	if inStockStr := r.FormValue("in_stock"); inStockStr != "" {
+		inStock, err := strconv.ParseBool(inStockStr)
+		if err != nil {
+			errors = append(errors, "error: in_stock is not a boolean")
+		}
+		params.inStock = inStock
+	}
+
+	// a "-" prefix sorts in descending order
+	if sortStr := r.FormValue("sort"); sortStr != "" {
+		params.desc = strings.HasPrefix(sortStr, "-")
+		params.sort = strings.TrimPrefix(sortStr, "-")
+		if _, ok := pieListSorts[params.sort]; !ok {
+			errors = append(errors, "error: sort must be one of id, price, name or remaining_slices")
+		}
+	}
+
+	if pageStr := r.FormValue("page"); pageStr != "" {
+		page, err := strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			errors = append(errors, "error: page is not a positive integer")
+		}
+		params.page = page
+	}
+
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPieListLimit {
+			errors = append(errors, "error: limit must be an integer between 1 and "+strconv.Itoa(maxPieListLimit))
+		}
+		params.limit = limit
+	}
+	return params, errors
+}
+
+// filterPies returns the pies matching the price range, stock and name of the
+// request sorted in the requested order
+func filterPies(pies pie.Pies, params pieListParams) pie.Pies {
+	filtered := pie.Pies{}
+	name := strings.ToLower(params.name)
+	for _, p := range pies {
+		if params.minPrice != nil && p.Price < *params.minPrice {
+			continue
+		}
+		if params.maxPrice != nil && p.Price > *params.maxPrice {
+			continue
+		}
+		if params.inStock && p.Slices == 0 {
+			continue
+		}
+		if name != "" && !strings.Contains(strings.ToLower(p.Name), name) {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+
+	// Sort by ID first so that pies that are equal are always in the same order
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].ID < filtered[j].ID
+	})
+
+	less := pieListSorts[params.sort]
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if params.desc {
+			return less(filtered[j], filtered[i])
+		}
+		return less(filtered[i], filtered[j])
+	})
+	return filtered
+}
+
+// paginatePies returns the requested page of the pies along with links to
+// the pages around it
+func paginatePies(r *http.Request, pies pie.Pies, params pieListParams) *pieListPage {
+	page := &pieListPage{
+		Pies:  pie.Pies{},
+		Total: len(pies),
+		Page:  params.page,
+		Limit: params.limit,
+		Pages: (len(pies) + params.limit - 1) / params.limit,
+	}
+
+	start := (params.page - 1) * params.limit
+	if start < len(pies) {
+		end := start + params.limit
+		if end > len(pies) {
+			end = len(pies)
+		}
+		page.Pies = pies[start:end]
+	}
+
+	if params.page > 1 && params.page <= page.Pages+1 {
+		page.Prev = pageURL(r, params.page-1)
+	}
+	if params.page < page.Pages {
+		page.Next = pageURL(r, params.page+1)
+	}
+	return page
+}
+
+// pageURL returns the URL of the request for another page
+func pageURL(r *http.Request, page int) string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	return "http://" + r.Host + r.URL.Path + "?" + query.Encode()
+}
+
+// newPieList returns the JSON list of a page of the pies, whose slices are
+// the remaining slices
+func newPieList(page *pieListPage) *pieListResponse {
+	list := &pieListResponse{
+		Pies:  []*pieListItem{},
+		Total: page.Total,
+		Page:  page.Page,
+		Limit: page.Limit,
+		Pages: page.Pages,
+		Prev:  page.Prev,
+		Next:  page.Next,
+	}
+	for _, p := range page.Pies {
+		labels := p.Labels
+		if labels == nil {
+			labels = []string{}
+		}
+
+		list.Pies = append(list.Pies, &pieListItem{
+			ID:              p.ID,
+			Name:            p.Name,
+			ImageURL:        p.ImageURL,
+			Price:           p.Price,
+			RemainingSlices: p.Slices,
+			Labels:          labels,
+			Permalink:       p.Permalink,
+		})
+	}
+	return list
+}
+
+// pieListCSV returns the CSV records of the pies, whose slices are the
+// remaining slices. Labels are separated by ";".
+func pieListCSV(pies pie.Pies) [][]string {
+	records := [][]string{pieListColumns}
+	for _, p := range pies {
+		records = append(records, []string{
+			strconv.FormatUint(p.ID, 10),
+			p.Name,
+			p.ImageURL,
+			p.Price.String(),
+			strconv.Itoa(p.Slices),
+			strings.Join(p.Labels, ";"),
+			p.Permalink,
+		})
+	}
+	return records
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/recommender"
+	"github.com/davinche/gpies/store"
+)
+
+// The default and maximum number of pies returned by the recommend endpoint
+const (
+	defaultRecommendLimit = 10
+	maxRecommendLimit     = 100
+)
+
+// recommendResponse is a page of the pies recommended to a user
+type recommendResponse struct {
+	Strategy string            `json:"strategy"`
+	Pies     pie.RecommendPies `json:"pies"`
+	Total    int               `json:"total"`
+	Limit    int               `json:"limit"`
+	Offset   int               `json:"offset"`
+
+	Explanation *explanation `json:"explanation,omitempty"`
+}
+
+// recommendParams are the options of a request for recommended pies
+type recommendParams struct {
+	username string
+	strategy recommender.Strategy
+	assigned bool
+	budget   string
+	maxPrice *pie.Cents
+	labels   store.LabelQuery
+	limit    int
+	offset   int
+	explain  bool
+}
+
+// getRecommended gets the recommended pies for a given user ranked by the
+// strategy picked by the request, or the one the user is assigned to
+func getRecommended(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	params, errors := getRecommendParams(r)
+	if errors != nil {
+		encodeBadRequest(w, errors...)
+		return
+	}
+
+	log.Printf("debug: username=%q, strategy=%q, budget=%q, maxPrice=%v, labels=%+v, limit=%d, offset=%d\n",
+		params.username, params.strategy.Name(), params.budget, params.maxPrice, params.labels, params.limit, params.offset)
+
+	candidates, err := pieStore.Recommend(params.username, params.labels)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+
+	// Filter by the maximum price
+	listOfPies := pie.RecommendPies{}
+	for _, p := range candidates {
+		if params.maxPrice != nil && p.Price > *params.maxPrice {
+			continue
+		}
+		listOfPies = append(listOfPies, p)
+	}
+
+	// Are there any pies to recommend
+	if len(listOfPies) == 0 {
+		var e *explanation
+		if params.explain {
+			e, err = explain(params, listOfPies, nil)
+			if err != nil {
+				storeError(w, err)
+				return
+			}
+		}
+		noRecommended(w, e)
+		return
+	}
+
+	// Sort by ID so that pies with the same price are always in the same order
+	sort.Slice(listOfPies, func(i, j int) bool {
+		return listOfPies[i].ID < listOfPies[j].ID
+	})
+
+	req := &recommender.Request{
+		Username: params.username,
+		Budget:   params.budget,
+		Labels:   params.labels,
+	}
+	result, err := params.strategy.Rank(req, listOfPies)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+
+	log.Printf("activity: recommend: username=%q, strategy=%q, assigned=%t, served=%q, pies=%d\n",
+		params.username, params.strategy.Name(), params.assigned, result.Strategy, len(result.Pies))
+
+	var e *explanation
+	if params.explain {
+		e, err = explain(params, result.Pies, result)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+	}
+	recommend(w, r, result, e, params)
+}
+
+// getRecommendParams validates the options of a request for recommended pies
+func getRecommendParams(r *http.Request) (params recommendParams, errors []string) {
+	params.username = r.FormValue("username")
+	params.budget = r.FormValue("budget")
+	params.limit = defaultRecommendLimit
+
+	// Use the strategy the user is assigned to unless the request picks one
+	if name := r.FormValue("strategy"); name != "" {
+		strategy, ok := strategies[name]
+		if !ok {
+			errors = append(errors, fmt.Sprintf("error: unknown strategy %q", name))
+		}
+		params.strategy = strategy
+	} else {
+		params.strategy = experiment.Assign(params.username)
+		params.assigned = true
+	}
+
+	if params.budget != "" && params.budget != "cheap" && params.budget != "premium" {
+		errors = append(errors, "error: budget must be cheap or premium")
+	}
+
+	params.labels = getLabelQuery(r)
+
+	if maxPriceStr := r.FormValue("max_price"); maxPriceStr != "" {
+		maxPrice, err := pie.ParseCents(maxPriceStr)
+		if err != nil || maxPrice < 0 {
+			errors = append(errors, "error: max_price is not a positive decimal")
+		}
+		params.maxPrice = &maxPrice
+	}
+
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxRecommendLimit {
+			errors = append(errors, "error: limit must be an integer between 1 and "+strconv.Itoa(maxRecommendLimit))
+		}
+		params.limit = limit
+	}
+
+	if explainStr := r.FormValue("explain"); explainStr != "" {
+		shouldExplain, err := strconv.ParseBool(explainStr)
+		if err != nil {
+			errors = append(errors, "error: explain is not a boolean")
+		}
+		params.explain = shouldExplain
+	}
+
+	if offsetStr := r.FormValue("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			errors = append(errors, "error: offset is not a positive integer")
+		}
+		params.offset = offset
+	}
+	return params, errors
+}
+
+// recommend returns a page of the recommended pies
+func recommend(w http.ResponseWriter, r *http.Request, result *recommender.Result, e *explanation, params recommendParams) {
+	listOfPies := result.Pies
+	resp := recommendResponse{
+		Strategy:    result.Strategy,
+		Pies:        pie.RecommendPies{},
+		Total:       len(listOfPies),
+		Limit:       params.limit,
+		Offset:      params.offset,
+		Explanation: e,
+	}
+
+	if params.offset < len(listOfPies) {
+		end := params.offset + params.limit
+		if end > len(listOfPies) {
+			end = len(listOfPies)
+		}
+		resp.Pies = listOfPies[params.offset:end]
+	}
+
+	for _, p := range resp.Pies {
+		p.Permalink = permalink(r, p.ID)
+	}
+	encodeJSON(w, resp, nil)
+}
+
+// noReommended pies for you sir
+func noRecommended(w http.ResponseWriter, e *explanation) {
+	encoder := json.NewEncoder(w)
+	err := struct {
+		errorResponse
+		Explanation *explanation `json:"explanation,omitempty"`
+	}{errorResponse{"Sorry we don’t have what you’re looking for.  Come back early tomorrow before the crowds come from the best pie selection."}, e}
+	w.WriteHeader(http.StatusNotFound)
+	encoder.Encode(err)
+}
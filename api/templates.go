@@ -32,11 +32,17 @@ const list = `
 			padding-top: 20px;
 			border-top: 3px solid #ccc;
 		}
+
+		nav {
+			margin: 20px auto;
+			max-width: 960px;
+			text-align: center;
+		}
 	</style>
 </head>
 <body>
 	<h1>Pies - Go have a taste of heaven</h1>
-	{{range $index, $pie := .}}
+	{{range $index, $pie := .Pies}}
 	<div>
 		<p>
 			<strong>Name: </strong> <a href="{{.Permalink}}">{{.Name}}</a>
@@ -47,7 +53,7 @@ const list = `
 		</p>
 
 		<p>
-			<strong>Price:</strong> ${{.Price | printf "%0.2f"}}
+			<strong>Price:</strong> ${{.Price}}
 		</p>
 
 		<p>
@@ -55,6 +61,11 @@ const list = `
 		</p>
 	</div>
 	{{end}}
+	<nav>
+		{{ if .Prev }}<a href="{{.Prev}}">Previous</a>{{ end }}
+		Page {{.Page}} of {{.Pages}} ({{.Total}} pies)
+		{{ if .Next }}<a href="{{.Next}}">Next</a>{{ end }}
+	</nav>
 </body>
 </html>
 `
@@ -103,7 +114,7 @@ const single = `
 		</p>
 
 		<p>
-			<strong>Price:</strong> ${{.Price | printf "%0.2f"}}
+			<strong>Price:</strong> ${{.Price}}
 		</p>
 
 		<p>
@@ -123,8 +134,199 @@ const single = `
 </html>
 `
 
+const receipt = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<title>Order {{.ID}}</title>
+	<style>
+		html { margin: 0; padding: 0 }
+		body {
+			margin: 0;
+			padding: 0;
+			color: #777;
+		}
+
+		h1 {
+			margin: 20px auto;
+			max-width: 960px;
+			text-align: center;
+		}
+		div {
+			margin: 20px auto 0;
+			padding: 0 20px;
+			max-width: 960px;
+		}
+
+		div + div {
+			padding-top: 20px;
+			border-top: 3px solid #ccc;
+		}
+	</style>
+</head>
+<body>
+	<h1>Order {{.ID}}</h1>
+	<div>
+		<p>
+			<strong>Customer:</strong> {{.Username}}
+		</p>
+
+		<p>
+			<strong>Date:</strong> {{.CreatedAt.Format "Jan 2, 2006 3:04 PM"}}
+		</p>
+	</div>
+	{{ range .Lines }}
+	<div>
+		<p>
+			<strong>Name: </strong> <a href="/pie/{{.PieID}}">{{.Name}}</a>
+		</p>
+
+		<p>
+			{{.Slices}} slice{{ if gt .Slices 1}}s{{ end }} at ${{.UnitPrice}} - ${{.Total}}{{ if .Refunded }}, {{.Refunded}} refunded{{ end }}
+		</p>
+	</div>
+	{{ end }}
+	<div>
+		<p>
+			<strong>Total:</strong> ${{.Total}}
+		</p>
+	</div>
+</body>
+</html>
+`
+
+const history = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<title>Purchases of {{.Username}}</title>
+	<style>
+		html { margin: 0; padding: 0 }
+		body {
+			margin: 0;
+			padding: 0;
+			color: #777;
+		}
+
+		h1, h2 {
+			margin: 20px auto;
+			max-width: 960px;
+			text-align: center;
+		}
+		div {
+			margin: 20px auto 0;
+			padding: 0 20px;
+			max-width: 960px;
+		}
+
+		div + div {
+			padding-top: 20px;
+			border-top: 3px solid #ccc;
+		}
+	</style>
+</head>
+<body>
+	<h1>Purchases of {{.Username}}</h1>
+	{{ range .Purchases }}
+	<div>
+		<p>
+			<strong>Name: </strong> <a href="/pie/{{.PieID}}">{{.Name}}</a>
+		</p>
+
+		<p>
+			{{.Slices}} slice{{ if ne .Slices 1}}s{{ end }} for ${{.Amount}}{{ if .Refunded }}, {{.Refunded}} refunded{{ end }}
+		</p>
+
+		<p>
+			<strong>Date:</strong> {{.PurchasedAt.Format "Jan 2, 2006 3:04 PM"}} - <a href="/orders/{{.OrderID}}">Order {{.OrderID}}</a>
+		</p>
+	</div>
+	{{ else }}
+	<div>
+		<p>No purchases yet.</p>
+	</div>
+	{{ end }}
+	<h2>Allowance</h2>
+	{{ range .Allowances }}
+	<div>
+		<p>
+			<strong>Name: </strong> <a href="/pie/{{.PieID}}">{{.Name}}</a>
+		</p>
+
+		<p>
+			{{.Slices}} slice{{ if ne .Slices 1}}s{{ end }} purchased, {{.Remaining}} more allowed
+		</p>
+	</div>
+	{{ end }}
+</body>
+</html>
+`
+
+const labels = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<title>Labels</title>
+	<style>
+		html { margin: 0; padding: 0 }
+		body {
+			margin: 0;
+			padding: 0;
+			color: #777;
+		}
+
+		h1 {
+			margin: 20px auto;
+			max-width: 960px;
+			text-align: center;
+		}
+		div {
+			margin: 20px auto 0;
+			padding: 0 20px;
+			max-width: 960px;
+		}
+
+		div + div {
+			padding-top: 20px;
+			border-top: 3px solid #ccc;
+		}
+	</style>
+</head>
+<body>
+	<h1>Labels</h1>
+	{{ range . }}
+	<div>
+		<p>
+			<strong>Name: </strong> <a href="/pies?labels={{.Name}}">{{.Name}}</a>
+		</p>
+
+		<p>
+			{{.Pies}} pie{{ if ne .Pies 1}}s{{ end }}, {{.InStock}} in stock
+		</p>
+	</div>
+	{{ else }}
+	<div>
+		<p>No labels yet.</p>
+	</div>
+	{{ end }}
+</body>
+</html>
+`
+
 // PiesList is the template for showing a list of pies
 var PiesList = template.Must(template.New("PiesList").Parse(list))
 
 // PiesSingle is the template for showing a specific pie
 var PiesSingle = template.Must(template.New("PiesList").Parse(single))
+
+// OrderReceipt is the template for showing the receipt of an order
+var OrderReceipt = template.Must(template.New("OrderReceipt").Parse(receipt))
+
+// UserHistory is the template for showing the purchases of a user
+var UserHistory = template.Must(template.New("UserHistory").Parse(history))
+
+// LabelsList is the template for showing every label
+var LabelsList = template.Must(template.New("LabelsList").Parse(labels))
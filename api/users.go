@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// getUserPurchases returns every purchase made by a user along with how many
+// more slices of each pie they may buy
+func getUserPurchases(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	username := params["username"]
+	isJSON := strings.HasSuffix(r.URL.Path, ".json")
+
+	history, err := pieStore.History(username)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+
+	// showing json? Or rendering template
+	if isJSON {
+		encodeJSON(w, history, nil)
+		return
+	}
+	UserHistory.Execute(w, history)
+}
@@ -8,15 +8,66 @@ import (
 	"github.com/kardianos/osext"
 )
 
+// StrategyWeight assigns a share of the users to a recommendation strategy
+type StrategyWeight struct {
+	Strategy string `json:"strategy"`
+	Weight   int    `json:"weight"`
+}
+
 type config struct {
+	Store         string `json:"store"`
 	Redis         string `json:"redishost"`
 	RedisPassword string `json:"redispass"`
+	RedisDB       int    `json:"redisdb"`
+	Namespace     string `json:"namespace"`
+
+	// MaxSlicesPerUser is the number of slices of a single pie a user may buy
+	// unless the pie sets its own max_slices_per_user
+	MaxSlicesPerUser int `json:"maxslicesperuser"`
+
+	// MaxSlicesPerDay is the number of slices across all pies a user may buy
+	// in a day. There is no daily limit when it is 0.
+	MaxSlicesPerDay int `json:"maxslicesperday"`
+
+	// IdempotencyWindow is the number of seconds a purchase response is
+	// replayed for requests with the same Idempotency-Key
+	IdempotencyWindow int `json:"idempotencywindow"`
+
+	// RecommendStrategies assigns users to the recommendation strategy used
+	// when a request does not pick one. Every user gets the filter strategy
+	// when it is empty.
+	RecommendStrategies []StrategyWeight `json:"recommendstrategies"`
+
+	// AdminToken is the bearer token required by the admin API, which is
+	// disabled when it is empty
+	AdminToken string `json:"admintoken"`
+
+	// DayStart is the local time, eg: "05:00", at which a new day starts for
+	// the daily limit. Every instance sharing the same Redis needs the same
+	// day start. The day starts at midnight when it is empty.
+	DayStart string `json:"daystart"`
+
+	// ResetTime is the local time at which the sales are archived, the slices
+	// of every pie are restored and the purchases of every user are cleared.
+	// It has to be the day start. There is no reset when it is empty.
+	ResetTime string `json:"resettime"`
+
+	// ProductionPlan is the number of slices of pies by day of the week, eg:
+	// {"saturday": {"1": 20}}. Pies that are not in the plan of the day get
+	// their catalog slices back on reset.
+	ProductionPlan map[string]map[string]int `json:"productionplan"`
+
+	// Taxonomy is the path of the file defining the implications and
+	// aliases of labels
+	Taxonomy string `json:"taxonomy"`
 }
 
 // Config contains configuration to run the app
 var Config config
 
-func init() {
+// Load reads the config.json next to the binary into Config.
+// Tests set the fields of Config directly instead.
+func Load() {
 	extDir, err := osext.ExecutableFolder()
 	if err != nil {
 		log.Fatalf("error: could not determine folder of binary")
@@ -35,4 +86,12 @@ func init() {
 	if Config.Redis == "" {
 		Config.Redis = ":6379"
 	}
+
+	if Config.MaxSlicesPerUser == 0 {
+		Config.MaxSlicesPerUser = 3
+	}
+
+	if Config.IdempotencyWindow == 0 {
+		Config.IdempotencyWindow = 24 * 60 * 60
+	}
 }
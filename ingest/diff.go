@@ -0,0 +1,134 @@
+package ingest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/davinche/gpies/pie"
+)
+
+// Change is a single field that differs between the stored and incoming pie
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Modification lists the changes made to an existing pie
+type Modification struct {
+	ID      uint64
+	Name    string
+	Changes []*Change
+}
+
+// Report describes what an ingest would do to the store
+type Report struct {
+	Added    pie.Pies
+	Removed  pie.Pies
+	Modified []*Modification
+	Problems []*Problem
+
+	// Lenient reports the problems as skipped pies instead of refusing the catalog
+	Lenient bool
+}
+
+// Valid is true when the incoming catalog can be ingested
+func (r *Report) Valid() bool {
+	return r.Lenient || len(r.Problems) == 0
+}
+
+// Print writes a human readable version of the report
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Added: %d, Removed: %d, Modified: %d\n", len(r.Added), len(r.Removed), len(r.Modified))
+
+	for _, p := range r.Added {
+		fmt.Fprintf(w, "+ %d %s\n", p.ID, p.Name)
+	}
+
+	for _, p := range r.Removed {
+		fmt.Fprintf(w, "- %d %s\n", p.ID, p.Name)
+	}
+
+	for _, m := range r.Modified {
+		fmt.Fprintf(w, "~ %d %s\n", m.ID, m.Name)
+		for _, c := range m.Changes {
+			fmt.Fprintf(w, "    %s: %s -> %s\n", c.Field, c.Old, c.New)
+		}
+	}
+
+	if len(r.Problems) == 0 {
+		return
+	}
+
+	if r.Lenient {
+		fmt.Fprintf(w, "\nThe following invalid pies will be skipped:\n")
+	} else {
+		fmt.Fprintf(w, "\nThe catalog is invalid:\n")
+	}
+	for _, problem := range r.Problems {
+		fmt.Fprintf(w, "! %s\n", problem)
+	}
+}
+
+// diff compares the stored catalog with the incoming pies.
+// Stored pies missing from the incoming pies are only reported as removed
+// when the ingest would actually remove them.
+func diff(stored, incoming pie.Pies, opts Options) *Report {
+	report := &Report{
+		Added:    pie.Pies{},
+		Removed:  pie.Pies{},
+		Modified: []*Modification{},
+	}
+
+	storedByID := map[uint64]*pie.Pie{}
+	for _, p := range stored {
+		storedByID[p.ID] = p
+	}
+
+	incomingIDs := map[uint64]bool{}
+	for _, p := range incoming {
+		incomingIDs[p.ID] = true
+		old, ok := storedByID[p.ID]
+		if !ok {
+			report.Added = append(report.Added, p)
+			continue
+		}
+
+		changes := compare(old, p)
+		if len(changes) > 0 {
+			report.Modified = append(report.Modified, &Modification{
+				ID:      p.ID,
+				Name:    p.Name,
+				Changes: changes,
+			})
+		}
+	}
+
+	if !opts.Upsert || opts.Retire {
+		for _, p := range stored {
+			if !incomingIDs[p.ID] {
+				report.Removed = append(report.Removed, p)
+			}
+		}
+	}
+	return report
+}
+
+// compare returns the field level changes between two versions of a pie
+func compare(old, p *pie.Pie) []*Change {
+	changes := []*Change{}
+	add := func(field, o, n string) {
+		if o != n {
+			changes = append(changes, &Change{field, o, n})
+		}
+	}
+
+	add("name", old.Name, p.Name)
+	add("image_url", old.ImageURL, p.ImageURL)
+	add("price_per_slice", old.Price.String(), p.Price.String())
+	add("slices", fmt.Sprintf("%d", old.Slices), fmt.Sprintf("%d", p.Slices))
+	add("labels", strings.Join(old.Labels, ","), strings.Join(p.Labels, ","))
+	add("max_slices_per_user", fmt.Sprintf("%d", old.MaxSlicesPerUser), fmt.Sprintf("%d", p.MaxSlicesPerUser))
+	return changes
+}
@@ -7,28 +7,46 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strconv"
 
 	"github.com/kardianos/osext"
 
-	"github.com/davinche/gpies/api"
-	"github.com/davinche/gpies/config"
 	"github.com/davinche/gpies/pie"
-	"github.com/garyburd/redigo/redis"
+	"github.com/davinche/gpies/store"
+	"github.com/davinche/gpies/taxonomy"
 )
 
-// FromURL ingests data into redis via the data from the s3 bucket
-func FromURL(url string) {
+// Options controls how the pies are ingested
+type Options struct {
+	// Upsert merges the pies into the store instead of replacing everything
+	Upsert bool
+
+	// Retire and Restock are passed along to the store when upserting
+	store.UpsertOptions
+
+	// Lenient skips invalid pies instead of refusing to ingest the catalog
+	Lenient bool
+
+	// DryRun prints a report of the changes instead of writing to the store.
+	// The process exits with a non-zero code when the catalog is invalid.
+	DryRun bool
+
+	// Taxonomy replaces the aliases among the labels of the pies and adds
+	// the labels they imply
+	Taxonomy *taxonomy.Taxonomy
+}
+
+// FromURL ingests data into the store via the data from the s3 bucket
+func FromURL(s store.PieStore, url string, opts Options) {
 	resp, err := http.Get(url)
 	if err != nil {
 		log.Fatalf("error: could not ingest from pies.json: err=%q\n", err)
 	}
-	ingest(resp.Body)
+	ingest(s, resp.Body, opts)
 }
 
 // FromFile ingests data from the pies.json file from disk.
 // Pies.json was obtained from the link in the bakeoff.
-func FromFile() {
+func FromFile(s store.PieStore, opts Options) {
 	execDir, err := osext.ExecutableFolder()
 	if err != nil {
 		log.Fatalf("error: could not determine path for pies.json: err=%q\n", err)
@@ -40,10 +58,10 @@ func FromFile() {
 	if err != nil {
 		log.Fatalf("error: could not ingest pies.json: err=%q\n", err)
 	}
-	ingest(file)
+	ingest(s, file, opts)
 }
 
-func ingest(r io.ReadCloser) {
+func ingest(s store.PieStore, r io.ReadCloser, opts Options) {
 	defer r.Close()
 
 	// Create the pie struct to deserialize into
@@ -54,89 +72,73 @@ func ingest(r io.ReadCloser) {
 	decoder := json.NewDecoder(r)
 	err := decoder.Decode(&pStruct)
 	if err != nil {
+		if opts.DryRun {
+			fmt.Fprintf(os.Stderr, "error: could not decode pies.json: err=%q\n", err)
+			os.Exit(1)
+		}
 		log.Fatalf("error: could not decode pies.json: err=%q\n", err)
 	}
 
-	redisOpts := []redis.DialOption{}
-	if config.Config.RedisPassword != "" {
-		redisOpts = append(redisOpts, redis.DialPassword(config.Config.RedisPassword))
+	// Apply the taxonomy so that the label sets contain the implied labels.
+	// Missing pies are reported by validate.
+	for _, p := range pStruct.Pies {
+		if p != nil && len(p.Labels) > 0 {
+			p.Labels = opts.Taxonomy.Expand(p.Labels)
+		}
 	}
 
-	// Connect to redis
-	conn, err := redis.Dial("tcp", config.Config.Redis, redisOpts...)
-	if err != nil {
-		log.Fatalf("error: could not connect to redis: err=%q\n", err)
-	}
+	// Make sure the catalog is safe to ingest
+	pies, problems := validate(pStruct.Pies)
 
-	// Flush Redis
-	_, err = conn.Do("flushall")
-	if err != nil {
-		log.Fatalf("error: could not flush redis: err=%q\n", err)
+	// Report the changes without touching the store
+	if opts.DryRun {
+		dryRun(s, pies, problems, opts)
+		return
 	}
 
-	// Create the pies
-	err = createPies(conn, pStruct.Pies)
-	if err != nil {
-		log.Fatalf("error: could not create pies in redis: err=%q\n", err)
+	if len(problems) > 0 {
+		if !opts.Lenient {
+			fmt.Fprintf(os.Stderr, "error: refusing to ingest invalid catalog:\n")
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "%s\n", p)
+			}
+			os.Exit(1)
+		}
+
+		for _, p := range problems {
+			log.Printf("warning: skipping invalid pie: %s\n", p)
+		}
 	}
-}
 
-// createPies creates a hash entry for each
-func createPies(conn redis.Conn, pies pie.Pies) error {
-	defer conn.Close()
+	// Merge the pies into the store
+	if opts.Upsert {
+		err = s.Upsert(pies, opts.UpsertOptions)
+		if err != nil {
+			log.Fatalf("error: could not upsert pies in store: err=%q\n", err)
+		}
+		return
+	}
 
-	// Serialize all the pies as json
-	piesSerialized, err := json.Marshal(pies)
+	// Replace the pies in the store
+	err = s.Load(pies)
 	if err != nil {
-		return err
+		log.Fatalf("error: could not create pies in store: err=%q\n", err)
 	}
-	_, err = conn.Do("SET", api.PiesJSONKey, piesSerialized)
+}
+
+// dryRun prints what the ingest would change in the store
+func dryRun(s store.PieStore, pies pie.Pies, problems []*Problem, opts Options) {
+	stored, err := s.Catalog()
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "error: could not get pies from store: err=%q\n", err)
+		os.Exit(1)
 	}
 
-	// Go through each pie and set the approriate pie information / indexes
-	for _, p := range pies {
-
-		pieIDString := strconv.FormatUint(p.ID, 10)
-		key := fmt.Sprintf(api.PieKey, pieIDString)
-		hkey := fmt.Sprintf(api.HPieKey, pieIDString)
-		slicesKey := fmt.Sprintf(api.PieSlicesKey, pieIDString)
-
-		// Marshal the pie
-		serialized, err := json.Marshal(p)
-		if err != nil {
-			return err
-		}
-
-		// Add Pies to Redis
-		conn.Send("MULTI")
-		conn.Send("SET", key, serialized)
-		conn.Send("SET", slicesKey, p.Slices)
-		conn.Send("SADD", api.PiesAvailableKey, pieIDString)
-		conn.Send("SADD", api.PiesTotalKey, pieIDString)
-
-		// Set the labels
-		for _, l := range p.Labels {
-			lName := fmt.Sprintf(api.LabelKey, l)
-			conn.Send("SADD", lName, pieIDString)
-		}
-
-		// Set the hash attributes of the pie
-		conn.Send(
-			"HMSET", hkey,
-			"id", pieIDString,
-			"name", p.Name,
-			"imageURL", p.ImageURL,
-			"price", strconv.FormatFloat(p.Price, 'f', -1, 64),
-		)
-
-		// Execute!
-		_, err = conn.Do("EXEC")
-		if err != nil {
-			return err
-		}
-		log.Printf("activity: create pie: id=%d", p.ID)
+	report := diff(stored, pies, opts)
+	report.Problems = problems
+	report.Lenient = opts.Lenient
+	report.Print(os.Stdout)
+	if !report.Valid() {
+		os.Exit(1)
 	}
-	return nil
 }
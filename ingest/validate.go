@@ -0,0 +1,96 @@
+package ingest
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/davinche/gpies/pie"
+)
+
+// labelPattern are the labels that can safely be used in the label:%s keys
+// and in the comma separated labels query
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Problem is a single problem found with a pie in the catalog
+type Problem struct {
+	Index   int
+	ID      uint64
+	Field   string
+	Message string
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("pie %d (id=%d): %s: %s", p.Index, p.ID, p.Field, p.Message)
+}
+
+// validate checks every incoming pie and collects all the problems found.
+// It also returns the pies that have no problems.
+func validate(pies pie.Pies) (pie.Pies, []*Problem) {
+	valid := pie.Pies{}
+	problems := []*Problem{}
+	seen := map[uint64]bool{}
+	for i, p := range pies {
+		// A null in the pies array has no fields to check
+		if p == nil {
+			problems = append(problems, &Problem{i, 0, "pie", "missing"})
+			continue
+		}
+
+		pieProblems := validatePie(i, p)
+		if seen[p.ID] {
+			pieProblems = append(pieProblems, &Problem{i, p.ID, "id", "duplicate id"})
+		}
+		seen[p.ID] = true
+
+		if len(pieProblems) > 0 {
+			problems = append(problems, pieProblems...)
+			continue
+		}
+		valid = append(valid, p)
+	}
+	return valid, problems
+}
+
+// ValidatePie checks the fields of a single pie outside of a catalog, eg: a
+// pie edited through the admin API
+func ValidatePie(p *pie.Pie) []*Problem {
+	return validatePie(0, p)
+}
+
+// validatePie checks the fields of a single pie
+func validatePie(index int, p *pie.Pie) []*Problem {
+	problems := []*Problem{}
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, &Problem{index, p.ID, field, fmt.Sprintf(format, args...)})
+	}
+
+	if strings.TrimSpace(p.Name) == "" {
+		add("name", "name is empty")
+	}
+
+	if p.Price <= 0 {
+		add("price_per_slice", "must be positive, got %s", p.Price)
+	}
+
+	if p.Slices < 0 {
+		add("slices", "must not be negative, got %d", p.Slices)
+	}
+
+	if p.MaxSlicesPerUser < 0 {
+		add("max_slices_per_user", "must not be negative, got %d", p.MaxSlicesPerUser)
+	}
+
+	u, err := url.Parse(p.ImageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("image_url", "not a valid http(s) url: %q", p.ImageURL)
+	}
+
+	for _, l := range p.Labels {
+		if !labelPattern.MatchString(l) {
+			add("labels", "label %q may only contain letters, digits, '_' and '-'", l)
+		}
+	}
+	return problems
+}
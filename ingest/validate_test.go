@@ -0,0 +1,78 @@
+package ingest
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/store"
+	"github.com/davinche/gpies/taxonomy"
+)
+
+func TestValidate(t *testing.T) {
+	apple := func() *pie.Pie {
+		return &pie.Pie{ID: 1, Name: "Apple Pie", ImageURL: "http://example.com/apple.jpg", Price: 150, Slices: 10, Labels: []string{"sweet"}}
+	}
+	tests := []struct {
+		name  string
+		edit  func(p *pie.Pie) *pie.Pie
+		field string
+	}{
+		{"valid", func(p *pie.Pie) *pie.Pie { return p }, ""},
+		{"missing", func(p *pie.Pie) *pie.Pie { return nil }, "pie"},
+		{"empty name", func(p *pie.Pie) *pie.Pie { p.Name = " "; return p }, "name"},
+		{"free", func(p *pie.Pie) *pie.Pie { p.Price = 0; return p }, "price_per_slice"},
+		{"negative slices", func(p *pie.Pie) *pie.Pie { p.Slices = -1; return p }, "slices"},
+		{"not http", func(p *pie.Pie) *pie.Pie { p.ImageURL = "ftp://example.com/apple.jpg"; return p }, "image_url"},
+		{"label with a space", func(p *pie.Pie) *pie.Pie { p.Labels = []string{"very sweet"}; return p }, "labels"},
+	}
+
+	for _, test := range tests {
+		valid, problems := validate(pie.Pies{test.edit(apple())})
+		if test.field == "" {
+			if len(valid) != 1 || len(problems) != 0 {
+				t.Errorf("%s: got problems %v, want none", test.name, problems)
+			}
+			continue
+		}
+		if len(valid) != 0 || len(problems) != 1 || problems[0].Field != test.field || problems[0].Index != 0 {
+			t.Errorf("%s: got problems %v, want a problem with %s", test.name, problems, test.field)
+		}
+	}
+
+	_, problems := validate(pie.Pies{nil})
+	if problems[0].String() != "pie 0 (id=0): pie: missing" {
+		t.Errorf("got problem %q, want the missing pie", problems[0])
+	}
+
+	_, problems = validate(pie.Pies{apple(), apple()})
+	if len(problems) != 1 || problems[0].Index != 1 || problems[0].Field != "id" {
+		t.Errorf("got problems %v, want the duplicate id of pie 1", problems)
+	}
+}
+
+func TestIngestMissingPie(t *testing.T) {
+	source := `{"pies": [null, {"id": 1, "name": "Apple Pie", "image_url": "http://example.com/apple.jpg", "price_per_slice": 1.50, "slices": 10, "labels": ["vegan"]}]}`
+	s := store.NewMemoryStore(store.Limits{})
+	opts := Options{
+		Lenient:  true,
+		Taxonomy: &taxonomy.Taxonomy{Implies: map[string][]string{"vegan": {"vegetarian"}}},
+	}
+	ingest(s, ioutil.NopCloser(strings.NewReader(source)), opts)
+
+	pies, err := s.Catalog()
+	if err != nil {
+		t.Fatalf("could not get the catalog: %v", err)
+	}
+	if len(pies) != 1 || len(pies[0].Labels) != 2 {
+		t.Errorf("got pies %v, want the apple pie with its implied label", pies)
+	}
+
+	s = store.NewMemoryStore(store.Limits{})
+	ingest(s, ioutil.NopCloser(strings.NewReader(`{"pies": [null]}`)), opts)
+	pies, err = s.Catalog()
+	if err != nil || len(pies) != 0 {
+		t.Errorf("got pies %v and error %v, want none", pies, err)
+	}
+}
@@ -2,35 +2,107 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/davinche/gpies/api"
+	"github.com/davinche/gpies/config"
 	"github.com/davinche/gpies/ingest"
+	"github.com/davinche/gpies/reset"
+	"github.com/davinche/gpies/store"
+	"github.com/davinche/gpies/taxonomy"
 	"github.com/dimfeld/httptreemux"
 )
 
 func main() {
+	config.Load()
+
+	// Subcommands work on the store and exit
+	if len(os.Args) > 1 && os.Args[1] == "restock" {
+		restockCommand(os.Args[2:])
+		return
+	}
+
 	shouldIngest := flag.Bool("i", false, "Ingestion: specify this boolean to repopulate Redis")
 	ingestURL := flag.String("s", "", "Ingestion URL: specify the URL that contains the JSON to be ingested")
+	upsert := flag.Bool("u", false, "Upsert: specify with -i to merge the pies into Redis instead of clearing it")
+	retire := flag.Bool("retire", false, "Retire: specify with -u to remove pies that are no longer in the source")
+	restock := flag.Bool("restock", false, "Restock: specify with -u to reset the remaining slices of existing pies")
+	lenient := flag.Bool("lenient", false, "Lenient: specify with -i to skip invalid pies instead of refusing to ingest")
+	dryRun := flag.Bool("n", false, "Dry run: specify with -i to print the changes the ingest would make and exit")
 	verbose := flag.Bool("v", false, "Verbose: specify to enable logging")
 	flag.Parse()
 
+	// The ingest flags do nothing on their own, so the server should not start
+	// as if they had been applied
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, name := range []string{"u", "retire", "restock", "lenient", "n"} {
+		if given[name] && !*shouldIngest {
+			usageError("-%s can only be specified with -i", name)
+		}
+	}
+	for _, name := range []string{"retire", "restock"} {
+		if given[name] && !*upsert {
+			usageError("-%s can only be specified with -u", name)
+		}
+	}
+
 	if !*verbose {
 		log.SetFlags(0)
 		log.SetOutput(ioutil.Discard)
 	}
 
-	if *shouldIngest {
+	pieStore, err := store.New()
+	if err != nil {
+		log.Fatalf("error: could not create store: err=%q\n", err)
+	}
+
+	labelTaxonomy, err := taxonomy.Load()
+	if err != nil {
+		log.Fatalf("error: could not load the label taxonomy: err=%q\n", err)
+	}
+
+	// The memory store starts out empty so it always needs to be populated
+	if *shouldIngest || config.Config.Store == "memory" {
+		opts := ingest.Options{
+			Upsert:   *upsert,
+			DryRun:   *dryRun,
+			Lenient:  *lenient,
+			Taxonomy: labelTaxonomy,
+			UpsertOptions: store.UpsertOptions{
+				Retire:  *retire,
+				Restock: *restock,
+			},
+		}
 		if *ingestURL != "" {
-			ingest.FromURL(*ingestURL)
+			ingest.FromURL(pieStore, *ingestURL, opts)
 		} else {
-			ingest.FromFile()
+			ingest.FromFile(pieStore, opts)
+		}
+		if *dryRun {
+			return
+		}
+	}
+
+	if config.Config.ResetTime != "" {
+		err = reset.Schedule(pieStore, config.Config.ResetTime, config.Config.ProductionPlan)
+		if err != nil {
+			log.Fatalf("error: could not schedule the daily reset: err=%q\n", err)
 		}
 	}
 
 	router := httptreemux.New()
-	api.Handle("/", router)
+	api.Handle("/", router, pieStore, labelTaxonomy)
 	log.Fatal(http.ListenAndServe(":31415", router))
 }
+
+// usageError prints a mistake in the flags and the usage and exits
+func usageError(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+	flag.Usage()
+	os.Exit(2)
+}
@@ -0,0 +1,32 @@
+package pie
+
+import "time"
+
+// UserPurchase is a number of slices of a pie a user bought in an order.
+// Slices and Amount do not include the slices that were refunded.
+type UserPurchase struct {
+	OrderID     string    `json:"order_id"`
+	PieID       uint64    `json:"pie_id"`
+	Name        string    `json:"name"`
+	Slices      int       `json:"slices"`
+	Refunded    int       `json:"refunded"`
+	Amount      Cents     `json:"amount"`
+	PurchasedAt time.Time `json:"purchased_at"`
+}
+
+// Allowance is the number of slices of a pie a user holds and how many more
+// slices of it they may still buy
+type Allowance struct {
+	PieID     uint64 `json:"pie_id"`
+	Name      string `json:"name"`
+	Slices    int    `json:"slices"`
+	Remaining int    `json:"remaining"`
+}
+
+// History contains every purchase made by a user as well as their
+// remaining allowance for each pie
+type History struct {
+	Username   string          `json:"username"`
+	Purchases  []*UserPurchase `json:"purchases"`
+	Allowances []*Allowance    `json:"allowances"`
+}
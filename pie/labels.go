@@ -0,0 +1,8 @@
+package pie
+
+// Label is a label along with the number of pies carrying it
+type Label struct {
+	Name    string `json:"name"`
+	Pies    int    `json:"pies"`
+	InStock int    `json:"in_stock"`
+}
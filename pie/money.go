@@ -0,0 +1,77 @@
+package pie
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Cents is an amount of money in cents.
+// It is serialized to JSON as a decimal number with two decimal places.
+type Cents int64
+
+var hundred = big.NewRat(100, 1)
+
+// ParseCents parses a decimal amount of dollars such as "2.35" into cents.
+// Amounts with more than two decimal places are rounded half away from zero
+// to the nearest cent, eg: "2.345" is 235 and "2.3449" is 234.
+func ParseCents(s string) (Cents, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, "/") {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	r.Mul(r, hundred)
+
+	// Round half away from zero
+	remainder := new(big.Int)
+	cents, _ := new(big.Int).QuoRem(r.Num(), r.Denom(), remainder)
+	remainder.Abs(remainder).Mul(remainder, big.NewInt(2))
+	if remainder.Cmp(r.Denom()) >= 0 {
+		cents.Add(cents, big.NewInt(int64(r.Sign())))
+	}
+
+	if !cents.IsInt64() {
+		return 0, fmt.Errorf("amount %q is out of range", s)
+	}
+	return Cents(cents.Int64()), nil
+}
+
+// Times returns the amount multiplied by a number of slices
+func (c Cents) Times(slices int) Cents {
+	return c * Cents(slices)
+}
+
+// String formats the amount as dollars with two decimal places
+func (c Cents) String() string {
+	sign := ""
+	if c < 0 {
+		sign = "-"
+		c = -c
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, c/100, c%100)
+}
+
+// MarshalJSON writes the amount as a decimal number, eg: 2.35
+func (c Cents) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalJSON reads a decimal number, eg: 2.35, into cents.
+// Like the types of the standard library, null leaves the amount as is.
+func (c *Cents) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	cents, err := ParseCents(string(data))
+	if err != nil {
+		return err
+	}
+	*c = cents
+	return nil
+}
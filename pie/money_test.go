@@ -0,0 +1,140 @@
+package pie
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseCents(t *testing.T) {
+	tests := []struct {
+		amount string
+		cents  Cents
+		valid  bool
+	}{
+		{"2.35", 235, true},
+		{"7.05", 705, true},
+		{"0.1", 10, true},
+		{" 1.5 ", 150, true},
+		{"3", 300, true},
+		{"2.345", 235, true},
+		{"2.3449", 234, true},
+		{"0.005", 1, true},
+		{"0.0049", 0, true},
+		{"-1.50", -150, true},
+		{"-2.345", -235, true},
+		{"-2.3449", -234, true},
+		{"1e2", 10000, true},
+		{"2.35e-1", 24, true},
+		{"92233720368547758.07", 9223372036854775807, true},
+		{"92233720368547758.08", 0, false},
+		{"-92233720368547758.09", 0, false},
+		{"1e30", 0, false},
+		{"", 0, false},
+		{"abc", 0, false},
+		{"null", 0, false},
+		{"1/3", 0, false},
+		{"$2.35", 0, false},
+		{"2,35", 0, false},
+	}
+
+	for _, test := range tests {
+		cents, err := ParseCents(test.amount)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("ParseCents(%q): got %d, want an error", test.amount, cents)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCents(%q): got error %v", test.amount, err)
+			continue
+		}
+		if cents != test.cents {
+			t.Errorf("ParseCents(%q): got %d, want %d", test.amount, cents, test.cents)
+		}
+	}
+}
+
+func TestCentsTimes(t *testing.T) {
+	// The amount for 2.35 × 3 used to be rejected because float64 maths
+	// truncated it to 704 cents
+	price, err := ParseCents("2.35")
+	if err != nil {
+		t.Fatalf("could not parse the price: %v", err)
+	}
+	amount, err := ParseCents("7.05")
+	if err != nil {
+		t.Fatalf("could not parse the amount: %v", err)
+	}
+	if price.Times(3) != amount {
+		t.Errorf("got %s × 3 = %s, want %s", price, price.Times(3), amount)
+	}
+}
+
+func TestCentsString(t *testing.T) {
+	tests := []struct {
+		cents  Cents
+		amount string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{50, "0.50"},
+		{235, "2.35"},
+		{705, "7.05"},
+		{10000, "100.00"},
+		{-5, "-0.05"},
+		{-150, "-1.50"},
+		{9223372036854775807, "92233720368547758.07"},
+	}
+
+	for _, test := range tests {
+		if got := test.cents.String(); got != test.amount {
+			t.Errorf("Cents(%d).String(): got %q, want %q", test.cents, got, test.amount)
+		}
+	}
+}
+
+func TestCentsJSON(t *testing.T) {
+	tests := []struct {
+		cents Cents
+		json  string
+	}{
+		{235, "2.35"},
+		{705, "7.05"},
+		{0, "0.00"},
+		{-150, "-1.50"},
+	}
+
+	for _, test := range tests {
+		data, err := json.Marshal(test.cents)
+		if err != nil || string(data) != test.json {
+			t.Errorf("json.Marshal(%d): got %s and error %v, want %s", test.cents, data, err, test.json)
+		}
+
+		var cents Cents
+		err = json.Unmarshal([]byte(test.json), &cents)
+		if err != nil || cents != test.cents {
+			t.Errorf("json.Unmarshal(%s): got %d and error %v, want %d", test.json, cents, err, test.cents)
+		}
+	}
+
+	p := &Pie{}
+	err := json.Unmarshal([]byte(`{"price_per_slice": 2.345}`), p)
+	if err != nil || p.Price != 235 {
+		t.Errorf("got price %d and error %v, want 235", p.Price, err)
+	}
+
+	// null leaves the amount as is
+	p = &Pie{Price: 235}
+	err = json.Unmarshal([]byte(`{"price_per_slice": null}`), p)
+	if err != nil || p.Price != 235 {
+		t.Errorf("got price %d and error %v, want 235", p.Price, err)
+	}
+
+	for _, invalid := range []string{`{"price_per_slice": "2.35"}`, `{"price_per_slice": 1e30}`, `{"price_per_slice": true}`} {
+		err = json.Unmarshal([]byte(invalid), &Pie{})
+		if err == nil {
+			t.Errorf("json.Unmarshal(%s): got no error", invalid)
+		}
+	}
+}
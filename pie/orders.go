@@ -0,0 +1,24 @@
+package pie
+
+import "time"
+
+// OrderLine is a number of slices of a single pie in an order
+type OrderLine struct {
+	PieID     uint64 `json:"pie_id"`
+	Name      string `json:"name"`
+	Slices    int    `json:"slices"`
+	UnitPrice Cents  `json:"unit_price"`
+	Total     Cents  `json:"total"`
+
+	// Refunded is the number of slices of the line that were given back
+	Refunded int `json:"refunded,omitempty"`
+}
+
+// Order is a purchase of slices of one or more pies by a user
+type Order struct {
+	ID        string       `json:"id"`
+	Username  string       `json:"username"`
+	Lines     []*OrderLine `json:"lines"`
+	Total     Cents        `json:"total"`
+	CreatedAt time.Time    `json:"created_at"`
+}
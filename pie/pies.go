@@ -5,23 +5,31 @@ type Pie struct {
 	ID        uint64   `json:"id"`
 	Name      string   `json:"name"`
 	ImageURL  string   `json:"image_url"`
-	Price     float64  `json:"price_per_slice"`
+	Price     Cents    `json:"price_per_slice"`
 	Slices    int      `json:"slices,omitempty"`
 	Labels    []string `json:"labels"`
 	Permalink string   `json:"permalink,omitempty"`
+
+	// MaxSlicesPerUser overrides the configured number of slices of this pie
+	// a single user may buy
+	MaxSlicesPerUser int `json:"max_slices_per_user,omitempty"`
 }
 
-// RecommendPie is the struct used for recommending a pie
+// RecommendPie is the summary of a pie returned by the recommend endpoint
 type RecommendPie struct {
-	ID    uint64  `json:"id"`
-	Price float64 `json:"price_per_slice"`
+	ID              uint64 `json:"id"`
+	Name            string `json:"name"`
+	Price           Cents  `json:"price_per_slice"`
+	RemainingSlices int    `json:"remaining_slices"`
+	Permalink       string `json:"permalink"`
+	Reason          string `json:"reason,omitempty"`
 }
 
 // Pies is a list of pies
 type Pies []*Pie
 
 // RecommendPies only holds the necessary to serialize for the recommend endpoint.
-// Implements the sort interface to sort by budget.
+// Implements the sort interface to sort by price.
 type RecommendPies []*RecommendPie
 
 func (r RecommendPies) Len() int {
@@ -43,6 +51,14 @@ type Purchases struct {
 	Slices   int    `json:"slices"`
 }
 
+// Refund contains the number of slices a user gave back for a specific pie
+// and the amount of money returned to them
+type Refund struct {
+	Username string `json:"username"`
+	Slices   int    `json:"slices"`
+	Amount   Cents  `json:"amount"`
+}
+
 // Details contains the Pie information as well as the user purchases
 type Details struct {
 	*Pie
@@ -0,0 +1,17 @@
+package pie
+
+// Sales is what was sold of a pie in a day
+type Sales struct {
+	PieID      uint64 `json:"pie_id"`
+	Name       string `json:"name"`
+	Slices     int    `json:"slices"`
+	Amount     Cents  `json:"amount"`
+	Purchasers int    `json:"purchasers"`
+	Remaining  int    `json:"remaining_slices"`
+}
+
+// DailySales is what was sold of every pie in a day
+type DailySales struct {
+	Day  string   `json:"day"`
+	Pies []*Sales `json:"pies"`
+}
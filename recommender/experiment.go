@@ -0,0 +1,59 @@
+package recommender
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/davinche/gpies/config"
+)
+
+// arm is a strategy along with its share of the users
+type arm struct {
+	strategy Strategy
+	weight   int
+}
+
+// Experiment assigns users to strategies by a hash of their username so that
+// a user is always served by the same strategy
+type Experiment struct {
+	arms  []*arm
+	total int
+}
+
+// NewExperiment returns an experiment that splits the users between the
+// strategies by weight. Every user is assigned the filter strategy when there
+// are no weights.
+func NewExperiment(strategies map[string]Strategy, weights []config.StrategyWeight) (*Experiment, error) {
+	if len(weights) == 0 {
+		weights = []config.StrategyWeight{{Strategy: Filter, Weight: 1}}
+	}
+
+	e := &Experiment{}
+	for _, w := range weights {
+		strategy, ok := strategies[w.Strategy]
+		if !ok {
+			return nil, fmt.Errorf("unknown strategy %q", w.Strategy)
+		}
+		if w.Weight < 1 {
+			return nil, fmt.Errorf("weight of strategy %q is not a positive integer", w.Strategy)
+		}
+		e.arms = append(e.arms, &arm{strategy, w.Weight})
+		e.total += w.Weight
+	}
+	return e, nil
+}
+
+// Assign returns the strategy of a user
+func (e *Experiment) Assign(username string) Strategy {
+	h := fnv.New32a()
+	h.Write([]byte(username))
+	bucket := int(h.Sum32() % uint32(e.total))
+
+	for _, a := range e.arms {
+		if bucket < a.weight {
+			return a.strategy
+		}
+		bucket -= a.weight
+	}
+	return e.arms[len(e.arms)-1].strategy
+}
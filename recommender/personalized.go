@@ -0,0 +1,121 @@
+package recommender
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/store"
+)
+
+// personalized orders the pies by how well they match the purchases of a user.
+// A pie scores higher when it carries the labels of the slices the user
+// bought, when its price is within the range the user bought in and when more
+// of the users who bought the same pies also bought it.
+// Users who have not bought anything yet are served by the fallback strategy.
+type personalized struct {
+	store    store.PieStore
+	fallback Strategy
+}
+
+func (s *personalized) Name() string {
+	return Personalized
+}
+
+func (s *personalized) Rank(req *Request, pies pie.RecommendPies) (*Result, error) {
+	profile, err := s.store.Profile(req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile.IsEmpty() {
+		return s.fallback.Rank(req, pies)
+	}
+
+	catalog, err := s.store.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	personalize(pies, catalog, profile)
+	rule := "highest score from the labels and prices of your purchases and the purchases of similar users first, then lowest ID"
+	return &Result{Personalized, rule, pies}, nil
+}
+
+// personalize orders the pies by their score, keeping the order of pies with
+// the same score
+func personalize(pies pie.RecommendPies, catalog pie.Pies, profile *store.Profile) {
+	byID := map[uint64]*pie.Pie{}
+	for _, p := range catalog {
+		byID[p.ID] = p
+	}
+
+	// Weigh the labels and find the price range of the slices the user bought
+	labelWeights := map[string]float64{}
+	totalSlices := 0
+	var minPrice, maxPrice pie.Cents
+	for id, slices := range profile.Slices {
+		p, ok := byID[id]
+		if !ok {
+			continue
+		}
+
+		totalSlices += slices
+		for _, label := range p.Labels {
+			labelWeights[label] += float64(slices)
+		}
+
+		if minPrice == 0 || p.Price < minPrice {
+			minPrice = p.Price
+		}
+		if p.Price > maxPrice {
+			maxPrice = p.Price
+		}
+	}
+
+	maxCoPurchases := 0
+	for _, count := range profile.CoPurchases {
+		if count > maxCoPurchases {
+			maxCoPurchases = count
+		}
+	}
+
+	scores := map[uint64]float64{}
+	for _, rp := range pies {
+		// labels: the share of the slices held that carry each label,
+		// averaged over the labels of the pie
+		labelScore := 0.0
+		if p, ok := byID[rp.ID]; ok && len(p.Labels) > 0 && totalSlices > 0 {
+			for _, label := range p.Labels {
+				labelScore += labelWeights[label] / float64(totalSlices)
+			}
+			labelScore /= float64(len(p.Labels))
+		}
+
+		// price: full score within the range, less the further away it is
+		priceScore := 0.0
+		switch {
+		case maxPrice == 0:
+		case rp.Price < minPrice:
+			priceScore = float64(rp.Price) / float64(minPrice)
+		case rp.Price > maxPrice:
+			priceScore = float64(maxPrice) / float64(rp.Price)
+		default:
+			priceScore = 1
+		}
+
+		// co-purchases: relative to the most bought pie
+		coScore := 0.0
+		if maxCoPurchases > 0 {
+			coScore = float64(profile.CoPurchases[rp.ID]) / float64(maxCoPurchases)
+		}
+
+		scores[rp.ID] = labelScore + priceScore + coScore
+		rp.Reason = fmt.Sprintf("scored %.2f from your purchases: labels %.2f, price %.2f, bought by similar users %.2f",
+			scores[rp.ID], labelScore, priceScore, coScore)
+	}
+
+	sort.SliceStable(pies, func(i, j int) bool {
+		return scores[pies[i].ID] > scores[pies[j].ID]
+	})
+}
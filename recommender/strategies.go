@@ -0,0 +1,127 @@
+package recommender
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/store"
+)
+
+// filter keeps the pies ordered by ID unless the request has a budget
+type filter struct{}
+
+func (filter) Name() string {
+	return Filter
+}
+
+func (filter) Rank(req *Request, pies pie.RecommendPies) (*Result, error) {
+	var rule string
+	switch req.Budget {
+	case "cheap":
+		rule = byPrice(pies, false)
+	case "premium":
+		rule = byPrice(pies, true)
+	default:
+		rule = "lowest ID first"
+		for _, p := range pies {
+			p.Reason = "matches the filters, lowest ID first"
+		}
+	}
+	return &Result{Filter, rule, pies}, nil
+}
+
+// cheapest orders the pies by price, cheapest first
+type cheapest struct{}
+
+func (cheapest) Name() string {
+	return Cheapest
+}
+
+func (cheapest) Rank(req *Request, pies pie.RecommendPies) (*Result, error) {
+	rule := byPrice(pies, false)
+	return &Result{Cheapest, rule, pies}, nil
+}
+
+// premium orders the pies by price, most expensive first
+type premium struct{}
+
+func (premium) Name() string {
+	return Premium
+}
+
+func (premium) Rank(req *Request, pies pie.RecommendPies) (*Result, error) {
+	rule := byPrice(pies, true)
+	return &Result{Premium, rule, pies}, nil
+}
+
+// byPrice orders the pies by price, keeping the order of pies with the same
+// price, and returns the rule it used
+func byPrice(pies pie.RecommendPies, expensiveFirst bool) string {
+	order := "cheapest first"
+	if expensiveFirst {
+		sort.Stable(sort.Reverse(pies))
+		order = "most expensive first"
+	} else {
+		sort.Stable(pies)
+	}
+
+	for _, p := range pies {
+		p.Reason = fmt.Sprintf("$%s per slice, %s", p.Price, order)
+	}
+	return order + ", then lowest ID"
+}
+
+// random shuffles the pies. The shuffle is seeded by the username so that
+// a user gets the same order on every page of the same pies.
+type random struct{}
+
+func (random) Name() string {
+	return Random
+}
+
+func (random) Rank(req *Request, pies pie.RecommendPies) (*Result, error) {
+	h := fnv.New64a()
+	h.Write([]byte(req.Username))
+	r := rand.New(rand.NewSource(int64(h.Sum64())))
+	r.Shuffle(len(pies), func(i, j int) {
+		pies[i], pies[j] = pies[j], pies[i]
+	})
+
+	for _, p := range pies {
+		p.Reason = "picked at random"
+	}
+	return &Result{Random, "random order, the same for every page", pies}, nil
+}
+
+// mostPopular orders the pies by the number of slices users hold
+type mostPopular struct {
+	store store.PieStore
+}
+
+func (s *mostPopular) Name() string {
+	return MostPopular
+}
+
+func (s *mostPopular) Rank(req *Request, pies pie.RecommendPies) (*Result, error) {
+	sold := map[uint64]int{}
+	for _, p := range pies {
+		details, err := s.store.Pie(strconv.FormatUint(p.ID, 10))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, purchase := range details.Purchases {
+			sold[p.ID] += purchase.Slices
+		}
+		p.Reason = fmt.Sprintf("%d slices held by %d users, most popular first", sold[p.ID], len(details.Purchases))
+	}
+
+	sort.SliceStable(pies, func(i, j int) bool {
+		return sold[pies[i].ID] > sold[pies[j].ID]
+	})
+	return &Result{MostPopular, "most slices held by users first, then lowest ID", pies}, nil
+}
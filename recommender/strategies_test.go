@@ -0,0 +1,50 @@
+package recommender
+
+import (
+	"testing"
+
+	"github.com/davinche/gpies/pie"
+)
+
+func TestRandomIsStable(t *testing.T) {
+	candidates := func() pie.RecommendPies {
+		pies := pie.RecommendPies{}
+		for id := uint64(1); id <= 20; id++ {
+			pies = append(pies, &pie.RecommendPie{ID: id})
+		}
+		return pies
+	}
+	order := func(username string) []uint64 {
+		result, err := random{}.Rank(&Request{Username: username}, candidates())
+		if err != nil {
+			t.Fatalf("could not rank: %v", err)
+		}
+		ids := []uint64{}
+		for _, p := range result.Pies {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	// Every page is cut from the same order
+	first, again := order("bob"), order("bob")
+	seen := map[uint64]bool{}
+	for i := range first {
+		if first[i] != again[i] {
+			t.Fatalf("got %v then %v, want the same order", first, again)
+		}
+		seen[first[i]] = true
+	}
+	if len(seen) != 20 {
+		t.Errorf("got %v, want every pie once", first)
+	}
+
+	other := order("ann")
+	same := true
+	for i := range first {
+		same = same && first[i] == other[i]
+	}
+	if same {
+		t.Errorf("got the same order %v for another user", other)
+	}
+}
@@ -0,0 +1,65 @@
+package recommender
+
+import (
+	"github.com/davinche/gpies/pie"
+	"github.com/davinche/gpies/store"
+)
+
+// The names of the strategies
+const (
+	Filter       = "filter"
+	Personalized = "personalized"
+	Cheapest     = "cheapest"
+	Premium      = "premium"
+	Random       = "random"
+	MostPopular  = "most-popular"
+)
+
+// Request is what a strategy knows about the user asking for recommendations
+type Request struct {
+	Username string
+
+	// Budget is either "cheap", "premium" or empty
+	Budget string
+
+	// Labels is the label query the candidate pies were selected by
+	Labels store.LabelQuery
+}
+
+// Result is the pies ranked by a strategy
+type Result struct {
+	// Strategy is the name of the strategy that ranked the pies. It differs
+	// from the strategy that was asked for when that one fell back to another.
+	Strategy string
+
+	// Rule describes how the pies were ordered
+	Rule string
+
+	Pies pie.RecommendPies
+}
+
+// Strategy ranks the pies that can be recommended to a user
+type Strategy interface {
+	// Name is the name used to pick the strategy
+	Name() string
+
+	// Rank orders the candidate pies, which are sorted by ID, best first and
+	// sets the reason each pie was ranked where it is
+	Rank(req *Request, pies pie.RecommendPies) (*Result, error)
+}
+
+// Strategies returns every strategy by name
+func Strategies(s store.PieStore) map[string]Strategy {
+	strategies := map[string]Strategy{}
+	for _, strategy := range []Strategy{
+		filter{},
+		&personalized{store: s, fallback: filter{}},
+		cheapest{},
+		premium{},
+		random{},
+		&mostPopular{store: s},
+	} {
+		strategies[strategy.Name()] = strategy
+	}
+	return strategies
+}
@@ -0,0 +1,109 @@
+package reset
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davinche/gpies/store"
+)
+
+// Plan is the number of slices of each pie by day of the week
+type Plan map[time.Weekday]map[uint64]int
+
+// Schedule resets the store every day at the given local time, eg: "05:00",
+// until the process exits. The slices of the pies in the production plan of
+// the day, eg: {"saturday": {"1": 20}}, are restored to the planned number
+// instead of their catalog slices.
+func Schedule(s store.PieStore, at string, production map[string]map[string]int) error {
+	hour, minute, err := parseTime(at)
+	if err != nil {
+		return err
+	}
+
+	plan, err := parsePlan(production)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			now := time.Now()
+			next := nextReset(now, hour, minute)
+			log.Printf("debug: next reset at %s\n", next.Format(time.RFC3339))
+			time.Sleep(next.Sub(now))
+
+			err := Run(s, time.Now(), plan)
+			if err != nil {
+				log.Printf("error: could not reset the store: err=%q\n", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Run archives the sales of the day ending at now under the date it started
+// on, the day before now, restores the slices of every pie to the plan of the
+// day starting at now and clears the purchases of every user
+func Run(s store.PieStore, now time.Time, plan Plan) error {
+	ended := now.AddDate(0, 0, -1)
+	sales, err := s.Reset(ended.Format("2006-01-02"), plan[now.Weekday()])
+	if err != nil {
+		return err
+	}
+
+	for _, p := range sales.Pies {
+		log.Printf("activity: sales: day=%s, pie=%d, slices=%d, amount=%s, purchasers=%d, remaining=%d\n",
+			sales.Day, p.PieID, p.Slices, p.Amount, p.Purchasers, p.Remaining)
+	}
+	return nil
+}
+
+// nextReset returns the first time after now at the hour and minute
+func nextReset(now time.Time, hour, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, hour, minute, 0, 0, now.Location())
+	}
+	return next
+}
+
+// parseTime parses a time of the day such as "05:00"
+func parseTime(at string) (int, int, error) {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return 0, 0, fmt.Errorf("reset time %q is not formatted as HH:MM", at)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// parsePlan parses the production plan of the config
+func parsePlan(production map[string]map[string]int) (Plan, error) {
+	weekdays := map[string]time.Weekday{}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		weekdays[strings.ToLower(d.String())] = d
+	}
+
+	plan := Plan{}
+	for day, pies := range production {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("production plan: %q is not a day of the week", day)
+		}
+
+		plan[weekday] = map[uint64]int{}
+		for id, slices := range pies {
+			pieID, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("production plan: %s: %q is not a pie id", day, id)
+			}
+			if slices < 0 {
+				return nil, fmt.Errorf("production plan: %s: pie %s: slices must not be negative", day, id)
+			}
+			plan[weekday][pieID] = slices
+		}
+	}
+	return plan, nil
+}
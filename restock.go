@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/davinche/gpies/config"
+	"github.com/davinche/gpies/store"
+)
+
+// restockUsage is how the restock subcommand is used
+const restockUsage = "usage: gpies restock [-v] <id> <slices>"
+
+// restockCommand adds slices to a pie and makes it available again to the
+// users who have not reached their limit for it
+func restockCommand(args []string) {
+	flags := flag.NewFlagSet("restock", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "Verbose: specify to enable logging")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, restockUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if !*verbose {
+		log.SetFlags(0)
+		log.SetOutput(ioutil.Discard)
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	pieID := flags.Arg(0)
+	slices, err := strconv.Atoi(flags.Arg(1))
+	if err != nil || slices < 1 {
+		fmt.Fprintln(os.Stderr, "error: slices is not a positive integer")
+		os.Exit(2)
+	}
+
+	// The memory store only lives in the process of the server
+	if config.Config.Store == "memory" {
+		fmt.Fprintln(os.Stderr, "error: the memory store can only be restocked with POST /admin/pies/:id/restock")
+		os.Exit(1)
+	}
+
+	pieStore, err := store.New()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: could not create store: err=%q\n", err)
+		os.Exit(1)
+	}
+
+	remaining, err := pieStore.Restock(pieID, slices)
+	if err == store.ErrNotFound {
+		fmt.Fprintf(os.Stderr, "error: no pie with id %s\n", pieID)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: could not restock pie: err=%q\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("pie %s: %d slices remaining\n", pieID, remaining)
+}
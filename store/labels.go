@@ -0,0 +1,52 @@
+package store
+
+// LabelQuery selects pies by their labels
+type LabelQuery struct {
+	// All are the labels a pie must all carry
+	All []string
+
+	// Any are the labels a pie must carry at least one of
+	Any []string
+
+	// None are the labels a pie must not carry
+	None []string
+}
+
+// IsEmpty checks whether the query selects every pie
+func (q LabelQuery) IsEmpty() bool {
+	return len(q.All) == 0 && len(q.Any) == 0 && len(q.None) == 0
+}
+
+// Matches checks whether a pie with the given labels is selected by the query
+func (q LabelQuery) Matches(labels []string) bool {
+	carries := map[string]bool{}
+	for _, l := range labels {
+		carries[l] = true
+	}
+
+	for _, l := range q.All {
+		if !carries[l] {
+			return false
+		}
+	}
+
+	if len(q.Any) > 0 {
+		found := false
+		for _, l := range q.Any {
+			if carries[l] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, l := range q.None {
+		if carries[l] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davinche/gpies/pie"
+)
+
+// The limits a purchase can hit
+const (
+	LimitPie   = "pie"
+	LimitDaily = "daily"
+)
+
+// dailyWindow is how long the number of slices a user bought in a day is kept
+const dailyWindow = 48 * time.Hour
+
+// Limits are the maximum number of slices a user is allowed to buy
+type Limits struct {
+	// PerPie is the maximum number of slices of a single pie, unless the pie
+	// sets its own max_slices_per_user
+	PerPie int
+
+	// PerDay is the maximum number of slices across all pies in a day.
+	// There is no daily limit when it is 0.
+	PerDay int
+
+	// DayStart is the time of the day, since midnight, at which a new day
+	// starts for the daily limit, eg: 5 hours for a day start of 05:00
+	DayStart time.Duration
+}
+
+// LimitError is returned when a purchase would put the user over one of the limits
+type LimitError struct {
+	// Limit is the limit that was hit: LimitPie or LimitDaily
+	Limit string
+
+	// Max is the value of the limit that was hit
+	Max int
+
+	// Remaining is the number of slices of the pie the user can still buy
+	Remaining int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("gluttony is discouraged: %s limit of %d, %d remaining", e.Limit, e.Max, e.Remaining)
+}
+
+// maxPerPie returns the maximum number of slices of the pie a user may buy
+func (l Limits) maxPerPie(p *pie.Pie) int {
+	if p.MaxSlicesPerUser > 0 {
+		return p.MaxSlicesPerUser
+	}
+	return l.PerPie
+}
+
+// allowance is how many more slices of the pie a user may buy given how many
+// slices of the pie and how many slices in total they already bought today
+func (l Limits) allowance(pieMax, purchased, purchasedToday int) int {
+	remaining := pieMax - purchased
+	if l.PerDay > 0 && l.PerDay-purchasedToday < remaining {
+		remaining = l.PerDay - purchasedToday
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining
+}
+
+// check makes sure a user can buy the wanted slices given how many slices of
+// the pie and how many slices in total they already bought today
+func (l Limits) check(pieMax, purchased, purchasedToday, wanted int) *LimitError {
+	remaining := l.allowance(pieMax, purchased, purchasedToday)
+
+	if purchased+wanted > pieMax {
+		return &LimitError{LimitPie, pieMax, remaining}
+	}
+
+	if l.PerDay > 0 && purchasedToday+wanted > l.PerDay {
+		return &LimitError{LimitDaily, l.PerDay, remaining}
+	}
+	return nil
+}
+
+// today is the day used to count the slices a user bought across all pies.
+// A day starts at DayStart and is named after the date it started on.
+func (l Limits) today() string {
+	now := time.Now()
+	hour, minute := int(l.DayStart/time.Hour), int(l.DayStart%time.Hour/time.Minute)
+	start := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if now.Before(start) {
+		now = now.AddDate(0, 0, -1)
+	}
+	return now.Format("2006-01-02")
+}
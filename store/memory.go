@@ -0,0 +1,591 @@
+package store
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/davinche/gpies/pie"
+)
+
+// MemoryStore is a PieStore that keeps everything in memory.
+// It is useful for tests and demos where Redis is not available.
+type MemoryStore struct {
+	sync.Mutex
+	pies      pie.Pies
+	byID      map[string]*pie.Pie
+	slices    map[string]int
+	purchases map[string]map[string]int
+
+	// daily is the number of slices each user bought on each day
+	daily  map[string]map[string]int
+	limits Limits
+
+	orders      map[string]*pie.Order
+	userOrders  map[string][]string
+	dayOrders   []string
+	sales       map[string]*pie.DailySales
+	lastOrderID uint64
+
+	idempotency map[idempotencyKey]*idempotentResponse
+}
+
+// idempotentResponse is a saved response and when it can no longer be replayed
+// idempotencyKey is a key sent by a user, the same key sent by two users
+// is two keys
+type idempotencyKey struct {
+	username string
+	key      string
+}
+
+type idempotentResponse struct {
+	resp    *SavedResponse
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore(limits Limits) *MemoryStore {
+	s := &MemoryStore{
+		idempotency: map[idempotencyKey]*idempotentResponse{},
+		limits:      limits,
+	}
+	s.reset()
+	return s
+}
+
+func (s *MemoryStore) reset() {
+	s.pies = pie.Pies{}
+	s.byID = map[string]*pie.Pie{}
+	s.slices = map[string]int{}
+	s.purchases = map[string]map[string]int{}
+	s.daily = map[string]map[string]int{}
+	s.orders = map[string]*pie.Order{}
+	s.userOrders = map[string][]string{}
+	s.dayOrders = []string{}
+	s.sales = map[string]*pie.DailySales{}
+	s.lastOrderID = 0
+}
+
+// Load replaces the catalog with the given pies
+func (s *MemoryStore) Load(pies pie.Pies) error {
+	s.Lock()
+	defer s.Unlock()
+	s.reset()
+
+	for _, p := range pies {
+		pieID := strconv.FormatUint(p.ID, 10)
+		stored := s.copyPie(p)
+		s.pies = append(s.pies, stored)
+		s.byID[pieID] = stored
+		s.slices[pieID] = p.Slices
+		s.purchases[pieID] = map[string]int{}
+		log.Printf("activity: create pie: id=%d", p.ID)
+	}
+	return nil
+}
+
+// Upsert merges the pies into the catalog while keeping the remaining
+// slices and purchases of existing pies unless restocking
+func (s *MemoryStore) Upsert(pies pie.Pies, opts UpsertOptions) error {
+	s.Lock()
+	defer s.Unlock()
+
+	merged, changes := mergeCatalog(s.pies, pies, opts)
+	s.applyChanges(merged, changes, opts.Restock)
+	return nil
+}
+
+// EditPie changes a single pie of the catalog
+func (s *MemoryStore) EditPie(id uint64, edit func(old *pie.Pie) (*pie.Pie, error)) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var old *pie.Pie
+	if p, ok := s.byID[strconv.FormatUint(id, 10)]; ok {
+		old = s.copyPie(p)
+	}
+
+	edited, err := edit(old)
+	if err != nil {
+		return err
+	}
+
+	merged, changes := editCatalog(s.pies, id, edited)
+	s.applyChanges(merged, changes, false)
+	return nil
+}
+
+// applyChanges applies the changes to the catalog, which becomes the merged
+// catalog. Remaining slices are only set for new pies unless restocking.
+func (s *MemoryStore) applyChanges(merged pie.Pies, changes []*catalogChange, restock bool) {
+	for _, c := range changes {
+		pieID := strconv.FormatUint(c.ID, 10)
+		if c.Retired {
+			delete(s.byID, pieID)
+			delete(s.slices, pieID)
+			log.Printf("activity: retire pie: id=%d", c.ID)
+			continue
+		}
+
+		s.byID[pieID] = s.copyPie(c.New)
+		if c.Old == nil || restock {
+			s.slices[pieID] = c.New.Slices
+		}
+		if s.purchases[pieID] == nil {
+			s.purchases[pieID] = map[string]int{}
+		}
+		log.Printf("activity: upsert pie: id=%d", c.ID)
+	}
+
+	s.pies = pie.Pies{}
+	for _, p := range merged {
+		s.pies = append(s.pies, s.byID[strconv.FormatUint(p.ID, 10)])
+	}
+}
+
+// Catalog returns the pies as they were ingested
+func (s *MemoryStore) Catalog() (pie.Pies, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	pies := make(pie.Pies, len(s.pies))
+	for i, p := range s.pies {
+		pies[i] = s.copyPie(p)
+	}
+	return pies, nil
+}
+
+// Pies returns the list of pies selected by the label query
+func (s *MemoryStore) Pies(query LabelQuery) (pie.Pies, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	pies := pie.Pies{}
+	for _, p := range s.pies {
+		if !query.Matches(p.Labels) {
+			continue
+		}
+		cp := s.copyPie(p)
+		cp.Slices = s.slices[strconv.FormatUint(p.ID, 10)]
+		pies = append(pies, cp)
+	}
+	return pies, nil
+}
+
+// Labels returns every label along with the number of pies carrying it
+func (s *MemoryStore) Labels() ([]*pie.Label, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	byName := map[string]*pie.Label{}
+	labels := []*pie.Label{}
+	for _, p := range s.pies {
+		for _, name := range p.Labels {
+			label, ok := byName[name]
+			if !ok {
+				label = &pie.Label{Name: name}
+				byName[name] = label
+				labels = append(labels, label)
+			}
+
+			label.Pies++
+			if s.slices[strconv.FormatUint(p.ID, 10)] > 0 {
+				label.InStock++
+			}
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	return labels, nil
+}
+
+// Pie returns the information for a single pie
+func (s *MemoryStore) Pie(pieID string) (*pie.Details, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	p, ok := s.byID[pieID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	details := &pie.Details{
+		Pie:             s.copyPie(p),
+		RemainingSlices: s.slices[pieID],
+		Purchases:       []*pie.Purchases{},
+	}
+	details.Pie.Slices = 0
+
+	usernames := make([]string, 0, len(s.purchases[pieID]))
+	for username := range s.purchases[pieID] {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		details.Purchases = append(details.Purchases, &pie.Purchases{
+			Username: username,
+			Slices:   s.purchases[pieID][username],
+		})
+	}
+	return details, nil
+}
+
+// Purchase buys slices of a pie for a user as an order with a single line
+func (s *MemoryStore) Purchase(pieID, username string, amount pie.Cents, wantedSlices int) (*pie.Order, error) {
+	return purchase(s, pieID, username, amount, wantedSlices)
+}
+
+// Checkout buys the slices of every line of an order or none of them
+func (s *MemoryStore) Checkout(username string, lines []*pie.OrderLine, amount pie.Cents) (*pie.Order, error) {
+	err := validLines(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	order := &pie.Order{
+		Username:  username,
+		Lines:     mergeLines(lines),
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+	checkoutErr := &CheckoutError{}
+	day := s.limits.today()
+	totalSlices := 0
+	for _, l := range order.Lines {
+		totalSlices += l.Slices
+		pieID := strconv.FormatUint(l.PieID, 10)
+		p, ok := s.byID[pieID]
+		if !ok {
+			checkoutErr.Lines = append(checkoutErr.Lines, &LineError{l.PieID, ErrNotFound})
+			continue
+		}
+
+		limitErr := s.limits.check(s.limits.maxPerPie(p), s.purchases[pieID][username], s.daily[day][username], l.Slices)
+		if limitErr != nil {
+			checkoutErr.Lines = append(checkoutErr.Lines, &LineError{l.PieID, limitErr})
+			continue
+		}
+
+		remainingSlices := s.slices[pieID]
+		if remainingSlices == 0 {
+			checkoutErr.Lines = append(checkoutErr.Lines, &LineError{l.PieID, ErrSoldOut})
+			continue
+		}
+		if l.Slices > remainingSlices {
+			checkoutErr.Lines = append(checkoutErr.Lines, &LineError{l.PieID, ErrNotEnoughSlices})
+			continue
+		}
+
+		l.Name = p.Name
+		l.UnitPrice = p.Price
+		l.Total = p.Price.Times(l.Slices)
+		order.Total += l.Total
+	}
+
+	// The lines may be within the daily limit on their own but not together
+	if len(checkoutErr.Lines) == 0 {
+		purchasedToday := s.daily[day][username]
+		if s.limits.PerDay > 0 && purchasedToday+totalSlices > s.limits.PerDay {
+			checkoutErr.Err = &LimitError{LimitDaily, s.limits.PerDay, s.limits.PerDay - purchasedToday}
+		} else if order.Total != amount {
+			checkoutErr.Err = ErrWrongMaths
+		}
+	}
+
+	if checkoutErr.Err != nil || len(checkoutErr.Lines) > 0 {
+		return nil, checkoutErr
+	}
+
+	for _, l := range order.Lines {
+		pieID := strconv.FormatUint(l.PieID, 10)
+		s.slices[pieID] -= l.Slices
+		s.purchases[pieID][username] += l.Slices
+	}
+	if s.daily[day] == nil {
+		s.daily[day] = map[string]int{}
+	}
+	s.daily[day][username] += totalSlices
+
+	s.lastOrderID++
+	order.ID = strconv.FormatUint(s.lastOrderID, 10)
+	s.orders[order.ID] = order
+	s.userOrders[username] = append(s.userOrders[username], order.ID)
+	s.dayOrders = append(s.dayOrders, order.ID)
+	log.Printf("debug: success order: id=%s, user=%q, lines=%d, total=%s\n", order.ID, username, len(order.Lines), order.Total)
+	return copyOrder(order), nil
+}
+
+// Order returns an order record
+func (s *MemoryStore) Order(id string) (*pie.Order, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	order, ok := s.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	return copyOrder(order), nil
+}
+
+// History returns every purchase made by a user and their remaining allowances
+func (s *MemoryStore) History(username string) (*pie.History, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	orders := []*pie.Order{}
+	for _, id := range s.userOrders[username] {
+		orders = append(orders, s.orders[id])
+	}
+
+	held := map[uint64]int{}
+	for _, p := range s.pies {
+		held[p.ID] = s.purchases[strconv.FormatUint(p.ID, 10)][username]
+	}
+	return history(username, orders, s.pies, held, s.daily[s.limits.today()][username], s.limits), nil
+}
+
+// copyOrder returns a copy of an order so callers cannot modify the store
+func copyOrder(order *pie.Order) *pie.Order {
+	c := *order
+	c.Lines = make([]*pie.OrderLine, len(order.Lines))
+	for i, l := range order.Lines {
+		line := *l
+		c.Lines[i] = &line
+	}
+	return &c
+}
+
+// Restock adds slices to a pie
+func (s *MemoryStore) Restock(pieID string, slices int) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.byID[pieID]; !ok {
+		return 0, ErrNotFound
+	}
+
+	s.slices[pieID] += slices
+	log.Printf("activity: restock pie: id=%s, slices=%d, remaining=%d", pieID, slices, s.slices[pieID])
+	return s.slices[pieID], nil
+}
+
+// Reset archives the sales of the day, restores the slices of every pie and
+// clears the purchases of every user
+func (s *MemoryStore) Reset(day string, slices map[uint64]int) (*pie.DailySales, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	pies := pie.Pies{}
+	for _, p := range s.pies {
+		cp := s.copyPie(p)
+		cp.Slices = s.slices[strconv.FormatUint(p.ID, 10)]
+		pies = append(pies, cp)
+	}
+
+	orders := []*pie.Order{}
+	for _, id := range s.dayOrders {
+		orders = append(orders, s.orders[id])
+	}
+	sales := dailySales(day, pies, orders)
+	s.sales[day] = sales
+	s.dayOrders = []string{}
+
+	for _, p := range s.pies {
+		pieID := strconv.FormatUint(p.ID, 10)
+		pieSlices, ok := slices[p.ID]
+		if !ok {
+			pieSlices = p.Slices
+		}
+		s.slices[pieID] = pieSlices
+		s.purchases[pieID] = map[string]int{}
+	}
+	log.Printf("activity: reset: day=%s, pies=%d", day, len(s.pies))
+	return sales, nil
+}
+
+// Refund gives slices purchased by a user back to the pie
+func (s *MemoryStore) Refund(pieID, username string, slices int) (*pie.Refund, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	p, ok := s.byID[pieID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	purchased := s.purchases[pieID][username]
+	if slices == 0 {
+		slices = purchased
+	}
+	if purchased == 0 || slices > purchased {
+		return nil, ErrNotPurchased
+	}
+
+	s.slices[pieID] += slices
+	s.purchases[pieID][username] -= slices
+	if s.purchases[pieID][username] == 0 {
+		delete(s.purchases[pieID], username)
+	}
+
+	// The refunded slices no longer count towards the daily limit
+	day := s.limits.today()
+	if s.daily[day][username] > slices {
+		s.daily[day][username] -= slices
+	} else {
+		delete(s.daily[day], username)
+	}
+
+	// The slices are refunded at the price paid for them
+	orders := []*pie.Order{}
+	for i := len(s.userOrders[username]) - 1; i >= 0; i-- {
+		orders = append(orders, s.orders[s.userOrders[username][i]])
+	}
+	amount, unrecorded := refundLines(orders, p.ID, slices)
+	amount += p.Price.Times(unrecorded)
+
+	log.Printf("debug: refund: user=%q, pie=%q, slices=%d, amount=%s\n", username, pieID, slices, amount)
+	return &pie.Refund{
+		Username: username,
+		Slices:   slices,
+		Amount:   amount,
+	}, nil
+}
+
+// ClaimIdempotencyKey claims the key or returns the response saved for it
+func (s *MemoryStore) ClaimIdempotencyKey(username, key, request, claim string, lease time.Duration) (*SavedResponse, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// Forget the responses that can no longer be replayed
+	now := time.Now()
+	for k, saved := range s.idempotency {
+		if !now.Before(saved.expires) {
+			delete(s.idempotency, k)
+		}
+	}
+
+	k := idempotencyKey{username, key}
+	saved, ok := s.idempotency[k]
+	if ok {
+		resp := *saved.resp
+		return &resp, false, nil
+	}
+
+	s.idempotency[k] = &idempotentResponse{
+		resp:    &SavedResponse{Request: request, Claim: claim},
+		expires: time.Now().Add(lease),
+	}
+	return nil, true, nil
+}
+
+// ExtendIdempotencyKey extends the lease of a key still held by the claim
+func (s *MemoryStore) ExtendIdempotencyKey(username, key, claim string, lease time.Duration) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	saved, ok := s.idempotency[idempotencyKey{username, key}]
+	if !ok || !time.Now().Before(saved.expires) || saved.resp.Claim != claim || saved.resp.StatusCode != 0 {
+		return false, nil
+	}
+	saved.expires = time.Now().Add(lease)
+	return true, nil
+}
+
+// SaveIdempotentResponse saves the response for a claimed key
+func (s *MemoryStore) SaveIdempotentResponse(username, key string, resp *SavedResponse, window time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	saved := *resp
+	s.idempotency[idempotencyKey{username, key}] = &idempotentResponse{
+		resp:    &saved,
+		expires: time.Now().Add(window),
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a claimed key
+func (s *MemoryStore) ReleaseIdempotencyKey(username, key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.idempotency, idempotencyKey{username, key})
+	return nil
+}
+
+// Profile returns what is known about the purchases of a user
+func (s *MemoryStore) Profile(username string) (*Profile, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	orders := []*pie.Order{}
+	for _, id := range s.userOrders[username] {
+		orders = append(orders, s.orders[id])
+	}
+	profile := newProfile(orders, s.pies)
+
+	// Find the users who bought the same pies
+	similar := map[string]bool{}
+	for _, p := range s.pies {
+		if profile.Slices[p.ID] == 0 {
+			continue
+		}
+		for other, slices := range s.purchases[strconv.FormatUint(p.ID, 10)] {
+			if other != username && slices > 0 {
+				similar[other] = true
+			}
+		}
+	}
+
+	for _, p := range s.pies {
+		for other, slices := range s.purchases[strconv.FormatUint(p.ID, 10)] {
+			if similar[other] && slices > 0 {
+				profile.CoPurchases[p.ID]++
+			}
+		}
+	}
+	return profile, nil
+}
+
+// Recommend returns the pies that can be recommended to a user
+func (s *MemoryStore) Recommend(username string, query LabelQuery) (pie.RecommendPies, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	listOfPies := pie.RecommendPies{}
+
+	// Nothing can be recommended once the user reached the daily limit
+	if s.limits.PerDay > 0 && s.daily[s.limits.today()][username] >= s.limits.PerDay {
+		return listOfPies, nil
+	}
+
+	for _, p := range s.pies {
+		pieID := strconv.FormatUint(p.ID, 10)
+		if s.slices[pieID] == 0 || s.purchases[pieID][username] >= s.limits.maxPerPie(p) {
+			continue
+		}
+		if !query.Matches(p.Labels) {
+			continue
+		}
+		listOfPies = append(listOfPies, &pie.RecommendPie{
+			ID:              p.ID,
+			Name:            p.Name,
+			Price:           p.Price,
+			RemainingSlices: s.slices[pieID],
+		})
+	}
+	return listOfPies, nil
+}
+
+// copyPie returns a copy of a pie so callers cannot modify the store
+func (s *MemoryStore) copyPie(p *pie.Pie) *pie.Pie {
+	c := *p
+	c.Labels = append([]string{}, p.Labels...)
+	return &c
+}
@@ -0,0 +1,417 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davinche/gpies/config"
+	"github.com/davinche/gpies/pie"
+)
+
+// newTestStore creates a MemoryStore with an apple pie of 10 slices at 1.50
+// and a pecan pie of 2 slices at 2.35
+func newTestStore(t *testing.T, limits Limits) *MemoryStore {
+	s := NewMemoryStore(limits)
+	err := s.Load(pie.Pies{
+		{ID: 1, Name: "Apple Pie", Price: 150, Slices: 10, Labels: []string{"sweet"}},
+		{ID: 2, Name: "Pecan Pie", Price: 235, Slices: 2, Labels: []string{"sweet", "nutty"}},
+	})
+	if err != nil {
+		t.Fatalf("could not load pies: %v", err)
+	}
+	return s
+}
+
+// remaining returns the remaining slices of a pie
+func remaining(t *testing.T, s PieStore, id string) int {
+	details, err := s.Pie(id)
+	if err != nil {
+		t.Fatalf("could not get pie %s: %v", id, err)
+	}
+	return details.RemainingSlices
+}
+
+func TestPurchase(t *testing.T) {
+	tests := []struct {
+		name      string
+		pieID     string
+		amount    pie.Cents
+		slices    int
+		err       error
+		remaining int
+	}{
+		{"one slice", "1", 150, 1, nil, 9},
+		{"several slices", "1", 450, 3, nil, 7},
+		{"exact price", "2", 470, 2, nil, 0},
+		{"wrong maths", "1", 149, 1, ErrWrongMaths, 10},
+		{"not enough slices", "2", 705, 3, ErrNotEnoughSlices, 2},
+		{"unknown pie", "3", 150, 1, ErrNotFound, 0},
+		{"negative slices", "1", -150, -1, ErrInvalidSlices, 10},
+		{"no slices", "1", 0, 0, ErrInvalidSlices, 10},
+	}
+
+	for _, test := range tests {
+		s := newTestStore(t, Limits{PerPie: 5})
+		order, err := s.Purchase(test.pieID, "bob", test.amount, test.slices)
+		if err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			if test.err != ErrNotFound && remaining(t, s, test.pieID) != test.remaining {
+				t.Errorf("%s: the rejected purchase changed the remaining slices", test.name)
+			}
+			if h, _ := s.History("bob"); len(h.Purchases) != 0 {
+				t.Errorf("%s: the rejected purchase created an order", test.name)
+			}
+			continue
+		}
+
+		if order.Total != test.amount || len(order.Lines) != 1 || order.Lines[0].Slices != test.slices {
+			t.Errorf("%s: got order %+v", test.name, order)
+		}
+		if got := remaining(t, s, test.pieID); got != test.remaining {
+			t.Errorf("%s: got %d remaining slices, want %d", test.name, got, test.remaining)
+		}
+	}
+}
+
+func TestPurchaseLimits(t *testing.T) {
+	s := newTestStore(t, Limits{PerPie: 3, PerDay: 4})
+
+	_, err := s.Purchase("1", "bob", 450, 3)
+	if err != nil {
+		t.Fatalf("could not purchase: %v", err)
+	}
+
+	_, err = s.Purchase("1", "bob", 150, 1)
+	limitErr, ok := err.(*LimitError)
+	if !ok || limitErr.Limit != LimitPie || limitErr.Remaining != 0 {
+		t.Errorf("got error %v, want the pie limit", err)
+	}
+
+	_, err = s.Purchase("2", "bob", 470, 2)
+	limitErr, ok = err.(*LimitError)
+	if !ok || limitErr.Limit != LimitDaily || limitErr.Remaining != 1 {
+		t.Errorf("got error %v, want the daily limit with 1 remaining", err)
+	}
+
+	_, err = s.Purchase("2", "ann", 470, 2)
+	if err != nil {
+		t.Fatalf("could not purchase: %v", err)
+	}
+	_, err = s.Purchase("2", "bob", 235, 1)
+	if err != ErrSoldOut {
+		t.Errorf("got error %v, want %v", err, ErrSoldOut)
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	s := newTestStore(t, Limits{PerPie: 3, PerDay: 4})
+
+	lines := []*pie.OrderLine{{PieID: 1, Slices: 2}, {PieID: 2, Slices: 1}, {PieID: 1, Slices: 1}}
+	order, err := s.Checkout("bob", lines, 685)
+	if err != nil {
+		t.Fatalf("could not checkout: %v", err)
+	}
+	if len(order.Lines) != 2 || order.Lines[0].Slices != 3 || order.Total != 685 {
+		t.Errorf("got order %+v, want the lines of the same pie merged", order)
+	}
+
+	saved, err := s.Order(order.ID)
+	if err != nil || saved.Total != order.Total {
+		t.Errorf("got saved order %+v and error %v", saved, err)
+	}
+
+	// Every line is rejected or none is
+	_, err = s.Checkout("ann", []*pie.OrderLine{{PieID: 1, Slices: 1}, {PieID: 2, Slices: 2}}, 620)
+	checkoutErr, ok := err.(*CheckoutError)
+	if !ok || len(checkoutErr.Lines) != 1 || checkoutErr.Lines[0].Err != ErrNotEnoughSlices {
+		t.Fatalf("got error %v, want pie 2 rejected", err)
+	}
+	if got := remaining(t, s, "1"); got != 7 {
+		t.Errorf("got %d remaining slices of pie 1, want 7", got)
+	}
+
+	// The lines are within the daily limit on their own but not together
+	s = newTestStore(t, Limits{PerPie: 3, PerDay: 4})
+	_, err = s.Checkout("bob", []*pie.OrderLine{{PieID: 1, Slices: 3}, {PieID: 2, Slices: 2}}, 920)
+	checkoutErr, ok = err.(*CheckoutError)
+	if !ok {
+		t.Fatalf("got error %v, want a CheckoutError", err)
+	}
+	if limitErr, ok := checkoutErr.Err.(*LimitError); !ok || limitErr.Limit != LimitDaily {
+		t.Errorf("got error %v, want the daily limit", checkoutErr.Err)
+	}
+
+	_, err = s.Checkout("bob", []*pie.OrderLine{}, 0)
+	if err != ErrInvalidSlices {
+		t.Errorf("got error %v, want %v", err, ErrInvalidSlices)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	s := newTestStore(t, Limits{PerPie: 5})
+
+	_, err := s.Purchase("1", "bob", 300, 2)
+	if err != nil {
+		t.Fatalf("could not purchase: %v", err)
+	}
+
+	// The price changes after the purchase
+	err = s.EditPie(1, func(old *pie.Pie) (*pie.Pie, error) {
+		old.Price = 500
+		return old, nil
+	})
+	if err != nil {
+		t.Fatalf("could not edit pie: %v", err)
+	}
+
+	_, err = s.Purchase("1", "bob", 500, 1)
+	if err != nil {
+		t.Fatalf("could not purchase: %v", err)
+	}
+
+	// The most recent slices are refunded first
+	refund, err := s.Refund("1", "bob", 2)
+	if err != nil {
+		t.Fatalf("could not refund: %v", err)
+	}
+	if refund.Slices != 2 || refund.Amount != 650 {
+		t.Errorf("got refund %+v, want 2 slices for 6.50", refund)
+	}
+	if got := remaining(t, s, "1"); got != 9 {
+		t.Errorf("got %d remaining slices, want 9", got)
+	}
+
+	_, err = s.Refund("1", "bob", 2)
+	if err != ErrNotPurchased {
+		t.Errorf("got error %v, want %v", err, ErrNotPurchased)
+	}
+	_, err = s.Refund("3", "bob", 0)
+	if err != ErrNotFound {
+		t.Errorf("got error %v, want %v", err, ErrNotFound)
+	}
+
+	h, err := s.History("bob")
+	if err != nil {
+		t.Fatalf("could not get history: %v", err)
+	}
+	want := []struct {
+		slices, refunded int
+		amount           pie.Cents
+	}{{1, 1, 150}, {0, 1, 0}}
+	for i, w := range want {
+		p := h.Purchases[i]
+		if p.Slices != w.slices || p.Refunded != w.refunded || p.Amount != w.amount {
+			t.Errorf("purchase %d: got %+v, want %+v", i, p, w)
+		}
+	}
+	if h.Allowances[0].Slices != 1 || h.Allowances[0].Remaining != 4 {
+		t.Errorf("got allowance %+v, want 1 slice held and 4 remaining", h.Allowances[0])
+	}
+}
+
+func TestIdempotencyLease(t *testing.T) {
+	s := NewMemoryStore(Limits{})
+
+	_, claimed, err := s.ClaimIdempotencyKey("bob", "key", "request", "first", time.Millisecond)
+	if err != nil || !claimed {
+		t.Fatalf("got claimed %v and error %v, want the key claimed", claimed, err)
+	}
+
+	saved, claimed, _ := s.ClaimIdempotencyKey("bob", "key", "request", "second", time.Millisecond)
+	if claimed || saved.StatusCode != 0 {
+		t.Errorf("got claimed %v and response %+v, want the request in progress", claimed, saved)
+	}
+
+	// The keys of other users are other keys
+	_, claimed, _ = s.ClaimIdempotencyKey("ann", "key", "request", "other", time.Millisecond)
+	if !claimed {
+		t.Errorf("got the key of another user claimed")
+	}
+
+	// The lease expired before the response was saved
+	time.Sleep(2 * time.Millisecond)
+	held, err := s.ExtendIdempotencyKey("bob", "key", "first", time.Hour)
+	if err != nil || held {
+		t.Errorf("got held %v and error %v, want the lease expired", held, err)
+	}
+	_, claimed, _ = s.ClaimIdempotencyKey("bob", "key", "request", "second", time.Millisecond)
+	if !claimed {
+		t.Errorf("got the key still claimed after its lease")
+	}
+
+	// Only the attempt holding the key extends its lease
+	held, _ = s.ExtendIdempotencyKey("bob", "key", "first", time.Hour)
+	if held {
+		t.Errorf("got the lease extended by another attempt")
+	}
+	held, _ = s.ExtendIdempotencyKey("bob", "key", "second", time.Hour)
+	if !held {
+		t.Errorf("got the lease not extended by the attempt holding the key")
+	}
+	time.Sleep(2 * time.Millisecond)
+	_, claimed, _ = s.ClaimIdempotencyKey("bob", "key", "request", "third", time.Millisecond)
+	if claimed {
+		t.Errorf("got the key claimed again after its lease was extended")
+	}
+
+	err = s.SaveIdempotentResponse("bob", "key", &SavedResponse{Request: "request", StatusCode: 201}, time.Hour)
+	if err != nil {
+		t.Fatalf("could not save the response: %v", err)
+	}
+	saved, claimed, _ = s.ClaimIdempotencyKey("bob", "key", "request", "third", time.Millisecond)
+	if claimed || saved.StatusCode != 201 {
+		t.Errorf("got claimed %v and response %+v, want the saved response", claimed, saved)
+	}
+	held, _ = s.ExtendIdempotencyKey("bob", "key", "second", time.Hour)
+	if held {
+		t.Errorf("got the lease of a saved response extended")
+	}
+}
+
+func TestRestock(t *testing.T) {
+	s := newTestStore(t, Limits{PerPie: 5})
+
+	_, err := s.Purchase("2", "bob", 470, 2)
+	if err != nil {
+		t.Fatalf("could not purchase: %v", err)
+	}
+
+	pies, err := s.Recommend("ann", LabelQuery{All: []string{"nutty"}})
+	if err != nil || len(pies) != 0 {
+		t.Errorf("got %v and error %v, want no pies before the restock", pies, err)
+	}
+
+	got, err := s.Restock("2", 4)
+	if err != nil || got != 4 {
+		t.Errorf("got %d remaining slices and error %v, want 4", got, err)
+	}
+
+	pies, err = s.Recommend("ann", LabelQuery{All: []string{"nutty"}})
+	if err != nil || len(pies) != 1 || pies[0].RemainingSlices != 4 {
+		t.Errorf("got %v and error %v, want the restocked pie", pies, err)
+	}
+
+	_, err = s.Restock("3", 4)
+	if err != ErrNotFound {
+		t.Errorf("got error %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := newTestStore(t, Limits{PerPie: 5})
+
+	_, err := s.Purchase("1", "bob", 300, 2)
+	if err != nil {
+		t.Fatalf("could not purchase: %v", err)
+	}
+	_, err = s.Purchase("1", "ann", 450, 3)
+	if err != nil {
+		t.Fatalf("could not purchase: %v", err)
+	}
+	_, err = s.Refund("1", "ann", 3)
+	if err != nil {
+		t.Fatalf("could not refund: %v", err)
+	}
+	_, err = s.Purchase("2", "ann", 235, 1)
+	if err != nil {
+		t.Fatalf("could not purchase: %v", err)
+	}
+
+	// The price changes after the purchases
+	err = s.EditPie(1, func(old *pie.Pie) (*pie.Pie, error) {
+		old.Price = 500
+		return old, nil
+	})
+	if err != nil {
+		t.Fatalf("could not edit pie: %v", err)
+	}
+
+	sales, err := s.Reset("2026-10-16", map[uint64]int{2: 6})
+	if err != nil {
+		t.Fatalf("could not reset: %v", err)
+	}
+	want := []pie.Sales{
+		{PieID: 1, Name: "Apple Pie", Slices: 2, Amount: 300, Purchasers: 1, Remaining: 8},
+		{PieID: 2, Name: "Pecan Pie", Slices: 1, Amount: 235, Purchasers: 1, Remaining: 1},
+	}
+	if sales.Day != "2026-10-16" || len(sales.Pies) != len(want) {
+		t.Fatalf("got sales %+v", sales)
+	}
+	for i, w := range want {
+		if *sales.Pies[i] != w {
+			t.Errorf("got sales %+v, want %+v", *sales.Pies[i], w)
+		}
+	}
+
+	// The slices are restored to the plan or the catalog
+	if got := remaining(t, s, "1"); got != 10 {
+		t.Errorf("got %d remaining slices of pie 1, want 10", got)
+	}
+	if got := remaining(t, s, "2"); got != 6 {
+		t.Errorf("got %d remaining slices of pie 2, want 6", got)
+	}
+
+	details, _ := s.Pie("1")
+	if len(details.Purchases) != 0 {
+		t.Errorf("got purchases %v, want none after the reset", details.Purchases)
+	}
+
+	// The profile still knows what the user bought
+	profile, err := s.Profile("bob")
+	if err != nil || profile.Slices[1] != 2 {
+		t.Errorf("got profile %+v and error %v, want 2 slices of pie 1", profile, err)
+	}
+
+	// The slices bought before the reset can no longer be refunded, so no
+	// refund is left out of the archived sales
+	_, err = s.Refund("1", "bob", 1)
+	if err != ErrNotPurchased {
+		t.Errorf("got error %v, want %v", err, ErrNotPurchased)
+	}
+
+	// The orders are only archived once
+	sales, err = s.Reset("2026-10-17", nil)
+	if err != nil || sales.Pies[0].Slices != 0 || sales.Pies[1].Slices != 0 {
+		t.Errorf("got sales %+v and error %v, want nothing sold", sales, err)
+	}
+}
+
+func TestNewDayStart(t *testing.T) {
+	saved := config.Config
+	defer func() { config.Config = saved }()
+	tests := []struct {
+		dayStart, resetTime string
+		start               time.Duration
+		valid               bool
+	}{
+		{"", "", 0, true},
+		{"05:00", "", 5 * time.Hour, true},
+		{"05:30", "05:30", 5*time.Hour + 30*time.Minute, true},
+		{"", "05:00", 0, false},
+		{"05:00", "06:00", 0, false},
+		{"5am", "", 0, false},
+	}
+
+	for _, test := range tests {
+		config.Config.Store = "memory"
+		config.Config.DayStart = test.dayStart
+		config.Config.ResetTime = test.resetTime
+		s, err := New()
+		if !test.valid {
+			if err == nil {
+				t.Errorf("day start %q, reset time %q: got no error", test.dayStart, test.resetTime)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("day start %q, reset time %q: got error %v", test.dayStart, test.resetTime, err)
+			continue
+		}
+		if got := s.(*MemoryStore).limits.DayStart; got != test.start {
+			t.Errorf("day start %q: got %v, want %v", test.dayStart, got, test.start)
+		}
+	}
+}
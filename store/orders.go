@@ -0,0 +1,142 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/davinche/gpies/pie"
+)
+
+// LineError is the reason a line of an order was rejected
+type LineError struct {
+	PieID uint64
+	Err   error
+}
+
+// CheckoutError is returned when an order is rejected.
+// Err is set when the order as a whole is rejected, eg: because of the
+// daily limit or the amount, and Lines contains the rejected lines.
+type CheckoutError struct {
+	Err   error
+	Lines []*LineError
+}
+
+func (e *CheckoutError) Error() string {
+	msgs := []string{}
+	if e.Err != nil {
+		msgs = append(msgs, e.Err.Error())
+	}
+	for _, l := range e.Lines {
+		msgs = append(msgs, fmt.Sprintf("pie %d: %s", l.PieID, l.Err))
+	}
+	return fmt.Sprintf("order rejected: %s", strings.Join(msgs, "; "))
+}
+
+// purchase buys slices of a single pie as an order with one line.
+// The reason the line was rejected is returned as the error.
+func purchase(s PieStore, pieID, username string, amount pie.Cents, slices int) (*pie.Order, error) {
+	id, err := strconv.ParseUint(pieID, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	order, err := s.Checkout(username, []*pie.OrderLine{{PieID: id, Slices: slices}}, amount)
+	if checkoutErr, ok := err.(*CheckoutError); ok {
+		if len(checkoutErr.Lines) > 0 {
+			return nil, checkoutErr.Lines[0].Err
+		}
+		return nil, checkoutErr.Err
+	}
+	return order, err
+}
+
+// history builds the purchase history of a user from their orders, the
+// catalog, how many slices of each pie they hold and bought today
+func history(username string, orders []*pie.Order, catalog pie.Pies, held map[uint64]int, purchasedToday int, limits Limits) *pie.History {
+	h := &pie.History{
+		Username:   username,
+		Purchases:  []*pie.UserPurchase{},
+		Allowances: []*pie.Allowance{},
+	}
+
+	for _, order := range orders {
+		for _, l := range order.Lines {
+			h.Purchases = append(h.Purchases, &pie.UserPurchase{
+				OrderID:     order.ID,
+				PieID:       l.PieID,
+				Name:        l.Name,
+				Slices:      l.Slices - l.Refunded,
+				Refunded:    l.Refunded,
+				Amount:      l.UnitPrice.Times(l.Slices - l.Refunded),
+				PurchasedAt: order.CreatedAt,
+			})
+		}
+	}
+
+	for _, p := range catalog {
+		h.Allowances = append(h.Allowances, &pie.Allowance{
+			PieID:     p.ID,
+			Name:      p.Name,
+			Slices:    held[p.ID],
+			Remaining: limits.allowance(limits.maxPerPie(p), held[p.ID], purchasedToday),
+		})
+	}
+	return h
+}
+
+// refundLines records refunded slices of a pie against the lines of the
+// orders, which are given from the most recent one. It returns the amount
+// paid for the slices and the number of slices not found in the orders.
+func refundLines(orders []*pie.Order, pieID uint64, slices int) (pie.Cents, int) {
+	var amount pie.Cents
+	for _, order := range orders {
+		for _, l := range order.Lines {
+			if slices == 0 {
+				return amount, 0
+			}
+			if l.PieID != pieID || l.Refunded == l.Slices {
+				continue
+			}
+
+			refunded := l.Slices - l.Refunded
+			if refunded > slices {
+				refunded = slices
+			}
+			l.Refunded += refunded
+			amount += l.UnitPrice.Times(refunded)
+			slices -= refunded
+		}
+	}
+	return amount, slices
+}
+
+// validLines makes sure an order has lines and that every line buys at
+// least one slice
+func validLines(lines []*pie.OrderLine) error {
+	if len(lines) == 0 {
+		return ErrInvalidSlices
+	}
+	for _, l := range lines {
+		if l.Slices < 1 {
+			return ErrInvalidSlices
+		}
+	}
+	return nil
+}
+
+// mergeLines combines the lines of an order that are for the same pie
+func mergeLines(lines []*pie.OrderLine) []*pie.OrderLine {
+	merged := []*pie.OrderLine{}
+	byID := map[uint64]*pie.OrderLine{}
+	for _, l := range lines {
+		if m, ok := byID[l.PieID]; ok {
+			m.Slices += l.Slices
+			continue
+		}
+		m := &pie.OrderLine{PieID: l.PieID, Slices: l.Slices}
+		byID[l.PieID] = m
+		merged = append(merged, m)
+	}
+	return merged
+}
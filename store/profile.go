@@ -0,0 +1,42 @@
+package store
+
+import "github.com/davinche/gpies/pie"
+
+// Profile is what is known about the purchases of a user
+type Profile struct {
+	// Slices is the number of slices of each pie of the catalog the user
+	// bought across all of their orders, without the refunded slices
+	Slices map[uint64]int
+
+	// CoPurchases is the number of other users who bought each pie as well
+	// as at least one of the pies the user bought since the last reset
+	CoPurchases map[uint64]int
+}
+
+// IsEmpty checks whether the user has not bought anything
+func (p *Profile) IsEmpty() bool {
+	return len(p.Slices) == 0
+}
+
+// newProfile creates the profile of a user from their orders. Only the pies
+// of the catalog are kept.
+func newProfile(orders []*pie.Order, catalog pie.Pies) *Profile {
+	profile := &Profile{
+		Slices:      map[uint64]int{},
+		CoPurchases: map[uint64]int{},
+	}
+
+	inCatalog := map[uint64]bool{}
+	for _, p := range catalog {
+		inCatalog[p.ID] = true
+	}
+
+	for _, order := range orders {
+		for _, l := range order.Lines {
+			if inCatalog[l.PieID] && l.Slices > l.Refunded {
+				profile.Slices[l.PieID] += l.Slices - l.Refunded
+			}
+		}
+	}
+	return profile
+}
@@ -0,0 +1,1308 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davinche/gpies/pie"
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisStore is a PieStore backed by Redis.
+// Every key is prefixed with the namespace so several instances can share
+// the same Redis.
+type RedisStore struct {
+	pool   *redis.Pool
+	prefix string
+	limits Limits
+}
+
+// RedisOptions is where and how to connect to Redis
+type RedisOptions struct {
+	Host     string
+	Password string
+	DB       int
+
+	// Namespace is the prefix of every key, an empty namespace uses the
+	// keys without a prefix. It can not contain ":", which separates it from
+	// the keys, so that no namespace is the prefix of another one. It can
+	// not be one of the keyRoots either, so that the keys without a prefix
+	// are not in a namespace.
+	Namespace string
+}
+
+// keyRoots are the first parts of the keys of the store. Without a
+// namespace only the keys under them are cleared, which leaves the other
+// namespaces and the keys of other applications in Redis alone.
+var keyRoots = []string{"pies", "pie", "hpie", "label", "user", "orders", "order", "queries", "query", "sales", "idempotency"}
+
+// NewRedisStore creates a RedisStore with a connection pool to Redis
+func NewRedisStore(opts RedisOptions, limits Limits) (*RedisStore, error) {
+	if strings.Contains(opts.Namespace, ":") {
+		return nil, fmt.Errorf("namespace %q must not contain \":\"", opts.Namespace)
+	}
+	for _, root := range keyRoots {
+		if opts.Namespace == root {
+			return nil, fmt.Errorf("namespace %q is used by the keys without a namespace", opts.Namespace)
+		}
+	}
+
+	pool := &redis.Pool{
+		MaxIdle:   80,
+		MaxActive: 1000,
+		Dial: func() (redis.Conn, error) {
+			redisOpts := []redis.DialOption{redis.DialDatabase(opts.DB)}
+			if opts.Password != "" {
+				redisOpts = append(redisOpts, redis.DialPassword(opts.Password))
+			}
+			c, err := redis.Dial("tcp", opts.Host, redisOpts...)
+			if err != nil {
+				log.Printf("error: could not create redis connection: err=%q\n", err)
+			}
+			return c, err
+		},
+	}
+	prefix := ""
+	if opts.Namespace != "" {
+		prefix = opts.Namespace + ":"
+	}
+	return &RedisStore{pool: pool, prefix: prefix, limits: limits}, nil
+}
+
+// key formats one of the redis keys and adds the namespace prefix
+func (s *RedisStore) key(format string, args ...interface{}) string {
+	return s.prefix + fmt.Sprintf(format, args...)
+}
+
+// clear deletes every key in the namespace
+func (s *RedisStore) clear(conn redis.Conn) error {
+	patterns := []string{globEscaper.Replace(s.prefix) + "*"}
+	if s.prefix == "" {
+		patterns = []string{}
+		for _, root := range keyRoots {
+			patterns = append(patterns, root+":*")
+		}
+	}
+
+	for _, pattern := range patterns {
+		err := s.scan(conn, pattern, func(keys []interface{}) error {
+			_, err := conn.Do("DEL", keys...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan calls fn with every batch of keys matching the pattern
+func (s *RedisStore) scan(conn redis.Conn, match string, fn func(keys []interface{}) error) error {
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", match, "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+
+		cursor, err = redis.String(values[0], nil)
+		if err != nil {
+			return err
+		}
+
+		keys, err := redis.Values(values[1], nil)
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			err = fn(keys)
+			if err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// globEscaper escapes the characters that have a meaning in SCAN patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// Load clears the namespace and creates the pies
+func (s *RedisStore) Load(pies pie.Pies) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	// Clear everything in our namespace
+	err := s.clear(conn)
+	if err != nil {
+		return err
+	}
+
+	// Serialize all the pies as json
+	piesSerialized, err := json.Marshal(pies)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("SET", s.key(PiesJSONKey), piesSerialized)
+	if err != nil {
+		return err
+	}
+
+	// Go through each pie and set the approriate pie information / indexes
+	for _, p := range pies {
+		// Add Pies to Redis
+		conn.Send("MULTI")
+		err := s.sendPie(conn, p, true)
+		if err != nil {
+			conn.Do("DISCARD")
+			return err
+		}
+
+		// Execute!
+		_, err = conn.Do("EXEC")
+		if err != nil {
+			return err
+		}
+		log.Printf("activity: create pie: id=%d", p.ID)
+	}
+	return nil
+}
+
+// Upsert merges the pies into the catalog without clearing redis.
+// New pies are created, existing pies are updated in place and their
+// remaining slices and purchases are left alone unless restocking.
+func (s *RedisStore) Upsert(pies pie.Pies, opts UpsertOptions) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	stored, err := s.catalog(conn)
+	if err != nil {
+		return err
+	}
+
+	merged, changes := mergeCatalog(stored, pies, opts)
+
+	piesSerialized, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+
+	users, err := s.allUserSets(conn)
+	if err != nil {
+		return err
+	}
+
+	conn.Send("MULTI")
+	conn.Send("SET", s.key(PiesJSONKey), piesSerialized)
+	err = s.sendChanges(conn, changes, opts.Restock, users)
+	if err != nil {
+		conn.Do("DISCARD")
+		return err
+	}
+
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// EditPie changes a single pie of the catalog. The catalog is watched while
+// the pie is edited and the edit is retried when the catalog changed before
+// it could be saved.
+func (s *RedisStore) EditPie(id uint64, edit func(old *pie.Pie) (*pie.Pie, error)) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	for {
+		_, err := conn.Do("WATCH", s.key(PiesJSONKey))
+		if err != nil {
+			return err
+		}
+
+		stored, err := s.catalog(conn)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+
+		var old *pie.Pie
+		for _, p := range stored {
+			if p.ID == id {
+				cp := *p
+				old = &cp
+			}
+		}
+
+		edited, err := edit(old)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+
+		merged, changes := editCatalog(stored, id, edited)
+		piesSerialized, err := json.Marshal(merged)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+
+		// A new pie is made available to the users with their own set
+		var users []*userSets
+		if old == nil && edited != nil {
+			users, err = s.allUserSets(conn)
+			if err != nil {
+				conn.Do("UNWATCH")
+				return err
+			}
+		}
+
+		conn.Send("MULTI")
+		conn.Send("SET", s.key(PiesJSONKey), piesSerialized)
+		err = s.sendChanges(conn, changes, false, users)
+		if err != nil {
+			conn.Do("DISCARD")
+			return err
+		}
+
+		// EXEC returns nil when the catalog changed since it was watched
+		_, err = redis.Values(conn.Do("EXEC"))
+		if err == redis.ErrNil {
+			continue
+		}
+		return err
+	}
+}
+
+// sendChanges queues the commands that apply the changes to the catalog.
+// Remaining slices are only set for new pies unless restocking, in which case
+// the available pies of the given users are updated as well.
+func (s *RedisStore) sendChanges(conn redis.Conn, changes []*catalogChange, restock bool, users []*userSets) error {
+	withSlices := false
+	for _, c := range changes {
+		pieIDString := strconv.FormatUint(c.ID, 10)
+
+		// Remove the retired pies from the catalog and every index.
+		// Purchase history is kept.
+		if c.Retired {
+			conn.Send("DEL",
+				s.key(PieKey, pieIDString),
+				s.key(HPieKey, pieIDString),
+				s.key(PieSlicesKey, pieIDString),
+			)
+			conn.Send("SREM", s.key(PiesAvailableKey), pieIDString)
+			conn.Send("SREM", s.key(PiesTotalKey), pieIDString)
+			for _, l := range c.Old.Labels {
+				conn.Send("SREM", s.key(LabelKey, l), pieIDString)
+			}
+			log.Printf("activity: retire pie: id=%d", c.ID)
+			continue
+		}
+
+		// Remove stale label memberships
+		if c.Old != nil {
+			for _, l := range removedLabels(c.Old.Labels, c.New.Labels) {
+				conn.Send("SREM", s.key(LabelKey, l), pieIDString)
+			}
+		}
+
+		err := s.sendPie(conn, c.New, c.Old == nil || restock)
+		if err != nil {
+			return err
+		}
+		withSlices = withSlices || (c.Old == nil || restock) && c.New.Slices > 0
+		log.Printf("activity: upsert pie: id=%d", c.ID)
+	}
+
+	// The pies that got slices become available again to the users who
+	// have not reached their limit for them
+	if withSlices {
+		for _, u := range users {
+			conn.Send("SDIFFSTORE", u.available, s.key(PiesAvailableKey), u.unavailable)
+		}
+	}
+	return nil
+}
+
+// userSets are the keys of the sets of pies available and no longer
+// available to a user
+type userSets struct {
+	available   string
+	unavailable string
+}
+
+// allUserSets returns the sets of every user with their own set of available pies
+func (s *RedisStore) allUserSets(conn redis.Conn) ([]*userSets, error) {
+	users := []*userSets{}
+	affixes := strings.Split(UserAvailableKey, "%s")
+	userPrefix := s.prefix + affixes[0]
+	match := globEscaper.Replace(userPrefix) + "*" + globEscaper.Replace(affixes[1])
+	err := s.scan(conn, match, func(keys []interface{}) error {
+		for _, k := range keys {
+			userAvailableKey, err := redis.String(k, nil)
+			if err != nil {
+				return err
+			}
+			username := strings.TrimSuffix(strings.TrimPrefix(userAvailableKey, userPrefix), affixes[1])
+			users = append(users, &userSets{userAvailableKey, s.key(UserUnavailableKey, username)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Catalog returns the pies as they were ingested
+func (s *RedisStore) Catalog() (pie.Pies, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	return s.catalog(conn)
+}
+
+// catalog returns the stored pies without their remaining slices
+func (s *RedisStore) catalog(conn redis.Conn) (pie.Pies, error) {
+	pies := pie.Pies{}
+	piesBytes, err := redis.Bytes(conn.Do("GET", s.key(PiesJSONKey)))
+	if err == redis.ErrNil {
+		return pies, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(piesBytes, &pies)
+	if err != nil {
+		return nil, err
+	}
+	return pies, nil
+}
+
+// sendPie queues the commands that store a pie and its indexes.
+// The remaining slices are only set when withSlices is true.
+func (s *RedisStore) sendPie(conn redis.Conn, p *pie.Pie, withSlices bool) error {
+	pieIDString := strconv.FormatUint(p.ID, 10)
+	key := s.key(PieKey, pieIDString)
+	hkey := s.key(HPieKey, pieIDString)
+	slicesKey := s.key(PieSlicesKey, pieIDString)
+
+	// Marshal the pie
+	serialized, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	conn.Send("SET", key, serialized)
+	if withSlices {
+		conn.Send("SET", slicesKey, p.Slices)
+		if p.Slices > 0 {
+			conn.Send("SADD", s.key(PiesAvailableKey), pieIDString)
+		} else {
+			conn.Send("SREM", s.key(PiesAvailableKey), pieIDString)
+		}
+	}
+	conn.Send("SADD", s.key(PiesTotalKey), pieIDString)
+
+	// Set the labels
+	for _, l := range p.Labels {
+		lName := s.key(LabelKey, l)
+		conn.Send("SADD", lName, pieIDString)
+	}
+
+	// Set the hash attributes of the pie
+	conn.Send(
+		"HMSET", hkey,
+		"id", pieIDString,
+		"name", p.Name,
+		"imageURL", p.ImageURL,
+		"price_cents", int64(p.Price),
+		"max", p.MaxSlicesPerUser,
+	)
+	return nil
+}
+
+// Pies returns the list of pies selected by the label query
+func (s *RedisStore) Pies(query LabelQuery) (pie.Pies, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	// Get all the pies
+	pies, err := s.catalog(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only keep the pies selected by the query
+	if !query.IsEmpty() {
+		pieIDs, err := s.queryLabels(conn, []interface{}{s.key(PiesTotalKey)}, query)
+		if err != nil {
+			return nil, err
+		}
+
+		selected := map[string]bool{}
+		for _, id := range pieIDs {
+			selected[id] = true
+		}
+
+		matching := pie.Pies{}
+		for _, p := range pies {
+			if selected[strconv.FormatUint(p.ID, 10)] {
+				matching = append(matching, p)
+			}
+		}
+		pies = matching
+	}
+
+	for _, p := range pies {
+		// Grab remainig slices for the pie
+		slicesKey := s.key(PieSlicesKey, strconv.FormatUint(p.ID, 10))
+		slices, err := redis.Int(conn.Do("GET", slicesKey))
+		if err != nil {
+			return nil, err
+		}
+		p.Slices = slices
+	}
+	return pies, nil
+}
+
+// Labels returns every label along with the number of pies in its set and
+// how many of them are also in the set of available pies
+func (s *RedisStore) Labels() ([]*pie.Label, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	labelKeys := []interface{}{}
+	err := s.scan(conn, s.keyPattern(LabelKey), func(keys []interface{}) error {
+		labelKeys = append(labelKeys, keys...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conn.Send("MULTI")
+	for _, k := range labelKeys {
+		conn.Send("SCARD", k)
+		conn.Send("SINTER", k, s.key(PiesAvailableKey))
+	}
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+
+	labelPrefix := s.key(LabelKey, "")
+	labels := []*pie.Label{}
+	for i, k := range labelKeys {
+		labelKey, err := redis.String(k, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		total, err := redis.Int(values[2*i], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		inStock, err := redis.Strings(values[2*i+1], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		labels = append(labels, &pie.Label{
+			Name:    strings.TrimPrefix(labelKey, labelPrefix),
+			Pies:    total,
+			InStock: len(inStock),
+		})
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	return labels, nil
+}
+
+// Pie returns the information for a single pie
+func (s *RedisStore) Pie(pieID string) (*pie.Details, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	// Redis Keys that we need
+	key := s.key(PieKey, pieID)
+	slicesKey := s.key(PieSlicesKey, pieID)
+	piePurchasersKey := s.key(PiePurchasersKey, pieID)
+	log.Printf("debug: pieKey=%q, slicesKey=%q, purchasersKey=%q\n", key, slicesKey, piePurchasersKey)
+
+	// Pie to eventually serialize
+	details := &pie.Details{
+		Purchases: []*pie.Purchases{},
+	}
+
+	conn.Send("MULTI")
+	conn.Send("GET", key)
+	conn.Send("GET", slicesKey)
+	conn.Send("SMEMBERS", piePurchasersKey)
+	resp, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the pie data from redis
+	if resp[0] == nil {
+		return nil, ErrNotFound
+	}
+	pieBytes, err := redis.Bytes(resp[0], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshall into pie object
+	err = json.Unmarshal(pieBytes, details)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the number of slices
+	slices, err := redis.Int(resp[1], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// get purchaser IDs
+	members, err := redis.Strings(resp[2], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get number of slices by each purchaser
+	for _, memberName := range members {
+		purchasesKey := s.key(PurchaseKey, pieID, memberName)
+		numSlices, err := redis.Int(conn.Do("GET", purchasesKey))
+		if err != nil {
+			return nil, err
+		}
+		details.Purchases = append(details.Purchases, &pie.Purchases{
+			Username: memberName,
+			Slices:   numSlices,
+		})
+	}
+
+	details.Pie.Slices = 0
+	details.RemainingSlices = slices
+	return details, nil
+}
+
+// Purchase buys slices of a pie for a user as an order with a single line
+func (s *RedisStore) Purchase(pieID, username string, amount pie.Cents, wantedSlices int) (*pie.Order, error) {
+	return purchase(s, pieID, username, amount, wantedSlices)
+}
+
+// Checkout buys the slices of every line of an order or none of them.
+// The checks and the purchases are performed atomically by a script.
+func (s *RedisStore) Checkout(username string, lines []*pie.OrderLine, amount pie.Cents) (*pie.Order, error) {
+	err := validLines(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	lines = mergeLines(lines)
+
+	// Rejected orders leave gaps in the order IDs
+	orderID, err := redis.Int64(conn.Do("INCR", s.key(OrdersNextKey)))
+	if err != nil {
+		return nil, err
+	}
+	id := strconv.FormatInt(orderID, 10)
+
+	keys := []interface{}{
+		s.key(UserAvailableKey, username),
+		s.key(UserUnavailableKey, username),
+		s.key(PiesAvailableKey),
+		s.key(UserDailyKey, username, s.limits.today()),
+		s.key(OrderKey, id),
+		s.key(UserOrdersKey, username),
+		s.key(DayOrdersKey),
+	}
+	args := []interface{}{
+		username,
+		int64(amount),
+		s.limits.PerPie,
+		s.limits.PerDay,
+		int64(dailyWindow / time.Second),
+		id,
+		time.Now().Format(time.RFC3339),
+	}
+	for _, l := range lines {
+		pieID := strconv.FormatUint(l.PieID, 10)
+		keys = append(keys,
+			s.key(PieKey, pieID),
+			s.key(HPieKey, pieID),
+			s.key(PieSlicesKey, pieID),
+			s.key(PiePurchasersKey, pieID),
+			s.key(PurchaseKey, pieID, username),
+		)
+		args = append(args, pieID, l.Slices)
+	}
+
+	// The number of keys depends on the number of lines
+	scriptArgs := append([]interface{}{len(keys)}, keys...)
+	values, err := redis.Values(checkoutScript.Do(conn, append(scriptArgs, args...)...))
+	if err != nil {
+		return nil, err
+	}
+
+	var outcome string
+	values, err = redis.Scan(values, &outcome)
+	if err != nil {
+		return nil, err
+	}
+
+	switch outcome {
+	case checkoutOK:
+	case checkoutRejected:
+		return nil, checkoutError(values)
+	default:
+		return nil, fmt.Errorf("unknown checkout outcome %q", outcome)
+	}
+
+	var record []byte
+	_, err = redis.Scan(values, &record)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := decodeOrder(record)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("debug: success order: id=%s, user=%q, lines=%d, total=%s\n", order.ID, username, len(order.Lines), order.Total)
+	return order, nil
+}
+
+// Order returns an order record
+func (s *RedisStore) Order(id string) (*pie.Order, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	record, err := redis.Bytes(conn.Do("GET", s.key(OrderKey, id)))
+	if err == redis.ErrNil {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeOrder(record)
+}
+
+// History returns every purchase made by a user and their remaining allowances
+func (s *RedisStore) History(username string) (*pie.History, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	orders, err := s.orders(conn, s.key(UserOrdersKey, username))
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := s.catalog(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the number of slices the user holds of each pie
+	held := map[uint64]int{}
+	for _, p := range catalog {
+		purchasesKey := s.key(PurchaseKey, strconv.FormatUint(p.ID, 10), username)
+		slices, err := redis.Int(conn.Do("GET", purchasesKey))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		held[p.ID] = slices
+	}
+
+	purchasedToday, err := redis.Int(conn.Do("GET", s.key(UserDailyKey, username, s.limits.today())))
+	if err != nil && err != redis.ErrNil {
+		return nil, err
+	}
+	return history(username, orders, catalog, held, purchasedToday, s.limits), nil
+}
+
+// orders returns the records of the orders whose IDs are in the list
+func (s *RedisStore) orders(conn redis.Conn, listKey string) ([]*pie.Order, error) {
+	orderIDs, err := redis.Strings(conn.Do("LRANGE", listKey, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	return s.orderRecords(conn, orderIDs)
+}
+
+// orderRecords returns the records of the orders, skipping the ones that
+// no longer exist
+func (s *RedisStore) orderRecords(conn redis.Conn, orderIDs []string) ([]*pie.Order, error) {
+	orders := []*pie.Order{}
+	for _, id := range orderIDs {
+		record, err := redis.Bytes(conn.Do("GET", s.key(OrderKey, id)))
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		order, err := decodeOrder(record)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// queryLabels returns the IDs of the pies that are in every one of the sets
+// and are selected by the label query. The any labels are combined with
+// SUNIONSTORE, intersected with the sets and the all labels with SINTERSTORE
+// and the none labels are removed with SDIFF.
+func (s *RedisStore) queryLabels(conn redis.Conn, sets []interface{}, query LabelQuery) ([]string, error) {
+	queryID, err := redis.Int64(conn.Do("INCR", s.key(QueriesNextKey)))
+	if err != nil {
+		return nil, err
+	}
+	anyKey := s.key(QueryKey, queryID, "any")
+	resultKey := s.key(QueryKey, queryID, "result")
+
+	for _, label := range query.All {
+		sets = append(sets, s.key(LabelKey, label))
+	}
+
+	conn.Send("MULTI")
+	if len(query.Any) > 0 {
+		union := []interface{}{anyKey}
+		for _, label := range query.Any {
+			union = append(union, s.key(LabelKey, label))
+		}
+		conn.Send("SUNIONSTORE", union...)
+		sets = append(sets, anyKey)
+	}
+
+	conn.Send("SINTERSTORE", append([]interface{}{resultKey}, sets...)...)
+
+	diff := []interface{}{resultKey}
+	for _, label := range query.None {
+		diff = append(diff, s.key(LabelKey, label))
+	}
+	conn.Send("SDIFF", diff...)
+	conn.Send("DEL", anyKey, resultKey)
+
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	return redis.Strings(values[len(values)-2], nil)
+}
+
+// redisOrder is an order record as it is saved by the checkout script.
+// Prices are in cents.
+type redisOrder struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	Lines     []struct {
+		PieID     uint64 `json:"pie_id,string"`
+		Name      string `json:"name"`
+		Slices    int    `json:"slices"`
+		UnitPrice int64  `json:"unit_price_cents"`
+		Refunded  int    `json:"refunded"`
+	} `json:"lines"`
+}
+
+// decodeOrder converts an order record saved by the checkout script into an order
+func decodeOrder(record []byte) (*pie.Order, error) {
+	saved := &redisOrder{}
+	err := json.Unmarshal(record, saved)
+	if err != nil {
+		return nil, err
+	}
+
+	order := &pie.Order{
+		ID:        saved.ID,
+		Username:  saved.Username,
+		CreatedAt: saved.CreatedAt,
+		Lines:     []*pie.OrderLine{},
+	}
+	for _, l := range saved.Lines {
+		line := &pie.OrderLine{
+			PieID:     l.PieID,
+			Name:      l.Name,
+			Slices:    l.Slices,
+			UnitPrice: pie.Cents(l.UnitPrice),
+			Refunded:  l.Refunded,
+		}
+		line.Total = line.UnitPrice.Times(line.Slices)
+		order.Lines = append(order.Lines, line)
+		order.Total += line.Total
+	}
+	return order, nil
+}
+
+// purchaseError converts the outcome of a purchase returned by a script into
+// the matching error. It returns nil when the purchase was successful.
+func purchaseError(values []interface{}) error {
+	var outcome string
+	limitErr := &LimitError{}
+	_, err := redis.Scan(values, &outcome, &limitErr.Limit, &limitErr.Max, &limitErr.Remaining)
+	if err != nil {
+		return err
+	}
+
+	switch outcome {
+	case purchaseOK:
+		return nil
+	case purchaseNotFound:
+		return ErrNotFound
+	case purchaseGluttony:
+		return limitErr
+	case purchaseSoldOut:
+		return ErrSoldOut
+	case purchaseNotEnough:
+		return ErrNotEnoughSlices
+	case purchaseWrongMaths:
+		return ErrWrongMaths
+	}
+	return fmt.Errorf("unknown purchase outcome %q", outcome)
+}
+
+// checkoutError converts the reasons an order was rejected by the checkout
+// script into a CheckoutError
+func checkoutError(values []interface{}) error {
+	var orderOutcome, lineOutcomes []interface{}
+	_, err := redis.Scan(values, &orderOutcome, &lineOutcomes)
+	if err != nil {
+		return err
+	}
+
+	checkoutErr := &CheckoutError{}
+	if len(orderOutcome) > 0 {
+		checkoutErr.Err = purchaseError(orderOutcome)
+	}
+
+	for _, lineOutcome := range lineOutcomes {
+		values, err := redis.Values(lineOutcome, nil)
+		if err != nil {
+			return err
+		}
+
+		lineErr := &LineError{}
+		values, err = redis.Scan(values, &lineErr.PieID)
+		if err != nil {
+			return err
+		}
+		lineErr.Err = purchaseError(values)
+		checkoutErr.Lines = append(checkoutErr.Lines, lineErr)
+	}
+	return checkoutErr
+}
+
+// Restock adds slices to a pie and makes it available again to everyone who
+// has not reached their limit for it.
+// The restock is performed atomically by a script given the sets of available
+// pies of the users that exist when it starts.
+func (s *RedisStore) Restock(pieID string, slices int) (int, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	keys := []interface{}{
+		s.key(HPieKey, pieID),
+		s.key(PieSlicesKey, pieID),
+		s.key(PiesAvailableKey),
+	}
+
+	// Find the users with their own set of available pies
+	users, err := s.allUserSets(conn)
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range users {
+		keys = append(keys, u.available, u.unavailable)
+	}
+
+	scriptArgs := append([]interface{}{len(keys)}, keys...)
+	scriptArgs = append(scriptArgs, pieID, slices)
+	values, err := redis.Values(restockScript.Do(conn, scriptArgs...))
+	if err != nil {
+		return 0, err
+	}
+
+	var outcome string
+	var remaining int
+	_, err = redis.Scan(values, &outcome, &remaining)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("debug: restock: pie=%q, slices=%d, users=%d, outcome=%q\n", pieID, slices, (len(keys)-3)/2, outcome)
+	switch outcome {
+	case restockOK:
+		log.Printf("activity: restock pie: id=%s, slices=%d, remaining=%d", pieID, slices, remaining)
+		return remaining, nil
+	case restockNotFound:
+		return 0, ErrNotFound
+	}
+	return 0, fmt.Errorf("unknown restock outcome %q", outcome)
+}
+
+// Reset archives the sales of the day, restores the slices of every pie and
+// clears the purchases and available pies of every user in one transaction.
+// Purchases made while the keys of the users are being collected may be kept.
+// Orders made while the sales are being archived count towards the next day.
+func (s *RedisStore) Reset(day string, slices map[uint64]int) (*pie.DailySales, error) {
+	pies, err := s.Pies(LabelQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	orderIDs, err := redis.Strings(conn.Do("LRANGE", s.key(DayOrdersKey), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := s.orderRecords(conn, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	sales := dailySales(day, pies, orders)
+
+	salesSerialized, err := json.Marshal(sales)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := s.catalog(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the keys of the purchases and available pies of every user
+	userKeys := []interface{}{}
+	for _, format := range []string{PurchaseKey, UserAvailableKey, UserUnavailableKey} {
+		err = s.scan(conn, s.keyPattern(format), func(keys []interface{}) error {
+			userKeys = append(userKeys, keys...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn.Send("MULTI")
+	conn.Send("SET", s.key(SalesKey, day), salesSerialized)
+	conn.Send("LTRIM", s.key(DayOrdersKey), len(orderIDs), -1)
+	conn.Send("DEL", s.key(PiesAvailableKey))
+	for _, p := range catalog {
+		pieID := strconv.FormatUint(p.ID, 10)
+		pieSlices, ok := slices[p.ID]
+		if !ok {
+			pieSlices = p.Slices
+		}
+
+		conn.Send("SET", s.key(PieSlicesKey, pieID), pieSlices)
+		if pieSlices > 0 {
+			conn.Send("SADD", s.key(PiesAvailableKey), pieID)
+		}
+		conn.Send("DEL", s.key(PiePurchasersKey, pieID))
+	}
+	if len(userKeys) > 0 {
+		conn.Send("DEL", userKeys...)
+	}
+
+	_, err = conn.Do("EXEC")
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("activity: reset: day=%s, pies=%d, user keys=%d", day, len(catalog), len(userKeys))
+	return sales, nil
+}
+
+// keyPattern returns the SCAN pattern matching every key of the format
+func (s *RedisStore) keyPattern(format string) string {
+	parts := strings.Split(format, "%s")
+	for i, part := range parts {
+		parts[i] = globEscaper.Replace(part)
+	}
+	return globEscaper.Replace(s.prefix) + strings.Join(parts, "*")
+}
+
+// Refund gives slices purchased by a user back to the pie.
+// The refund is performed atomically by a script.
+func (s *RedisStore) Refund(pieID, username string, slices int) (*pie.Refund, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	keys := []interface{}{
+		s.key(PieKey, pieID),
+		s.key(HPieKey, pieID),
+		s.key(PieSlicesKey, pieID),
+		s.key(PiePurchasersKey, pieID),
+		s.key(PurchaseKey, pieID, username),
+		s.key(UserAvailableKey, username),
+		s.key(UserUnavailableKey, username),
+		s.key(PiesAvailableKey),
+		s.key(UserDailyKey, username, s.limits.today()),
+	}
+
+	// The orders of the user hold the price paid for the slices
+	orderIDs, err := redis.Strings(conn.Do("LRANGE", s.key(UserOrdersKey, username), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	for i := len(orderIDs) - 1; i >= 0; i-- {
+		keys = append(keys, s.key(OrderKey, orderIDs[i]))
+	}
+
+	scriptArgs := append([]interface{}{len(keys)}, keys...)
+	scriptArgs = append(scriptArgs, pieID, username, slices)
+	values, err := redis.Values(refundScript.Do(conn, scriptArgs...))
+	if err != nil {
+		return nil, err
+	}
+
+	var outcome string
+	refund := &pie.Refund{Username: username}
+	_, err = redis.Scan(values, &outcome, &refund.Slices, &refund.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("debug: refund: user=%q, pie=%q, slices=%d, outcome=%q\n", username, pieID, refund.Slices, outcome)
+	switch outcome {
+	case refundOK:
+		return refund, nil
+	case refundNotFound:
+		return nil, ErrNotFound
+	case refundNotPurchased:
+		return nil, ErrNotPurchased
+	}
+	return nil, fmt.Errorf("unknown refund outcome %q", outcome)
+}
+
+// ClaimIdempotencyKey claims the key or returns the response saved for it
+func (s *RedisStore) ClaimIdempotencyKey(username, key, request, claim string, lease time.Duration) (*SavedResponse, bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	idempotencyKey := s.key(IdempotencyKey, username, key)
+	pending, err := json.Marshal(&SavedResponse{Request: request, Claim: claim})
+	if err != nil {
+		return nil, false, err
+	}
+
+	reply, err := conn.Do("SET", idempotencyKey, pending, "PX", int64(lease/time.Millisecond), "NX")
+	if err != nil {
+		return nil, false, err
+	}
+	if reply != nil {
+		return nil, true, nil
+	}
+
+	saved, err := redis.Bytes(conn.Do("GET", idempotencyKey))
+	if err == redis.ErrNil {
+		// The key expired in between so try again
+		return s.ClaimIdempotencyKey(username, key, request, claim, lease)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp := &SavedResponse{}
+	err = json.Unmarshal(saved, resp)
+	if err != nil {
+		return nil, false, err
+	}
+	return resp, false, nil
+}
+
+// ExtendIdempotencyKey extends the lease of a key still held by the claim
+func (s *RedisStore) ExtendIdempotencyKey(username, key, claim string, lease time.Duration) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	return redis.Bool(extendClaimScript.Do(conn, s.key(IdempotencyKey, username, key), claim, int64(lease/time.Millisecond)))
+}
+
+// SaveIdempotentResponse saves the response for a claimed key
+func (s *RedisStore) SaveIdempotentResponse(username, key string, resp *SavedResponse, window time.Duration) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	saved, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("SET", s.key(IdempotencyKey, username, key), saved, "PX", int64(window/time.Millisecond))
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a claimed key
+func (s *RedisStore) ReleaseIdempotencyKey(username, key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", s.key(IdempotencyKey, username, key))
+	return err
+}
+
+// Profile returns what is known about the purchases of a user
+func (s *RedisStore) Profile(username string) (*Profile, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	pies, err := s.catalog(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := s.orders(conn, s.key(UserOrdersKey, username))
+	if err != nil {
+		return nil, err
+	}
+
+	profile := newProfile(orders, pies)
+	if profile.IsEmpty() {
+		return profile, nil
+	}
+
+	purchasersKeys := []interface{}{}
+	for id := range profile.Slices {
+		purchasersKeys = append(purchasersKeys, s.key(PiePurchasersKey, strconv.FormatUint(id, 10)))
+	}
+
+	// Combine the purchasers of the pies the user bought into a temporary set
+	// and count how many of them bought each pie
+	queryID, err := redis.Int64(conn.Do("INCR", s.key(QueriesNextKey)))
+	if err != nil {
+		return nil, err
+	}
+	similarKey := s.key(QueryKey, queryID, "similar")
+
+	conn.Send("MULTI")
+	conn.Send("SUNIONSTORE", append([]interface{}{similarKey}, purchasersKeys...)...)
+	conn.Send("SREM", similarKey, username)
+	for _, p := range pies {
+		conn.Send("SINTER", s.key(PiePurchasersKey, strconv.FormatUint(p.ID, 10)), similarKey)
+	}
+	conn.Send("DEL", similarKey)
+
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range pies {
+		purchasers, err := redis.Strings(values[i+2], nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(purchasers) > 0 {
+			profile.CoPurchases[p.ID] = len(purchasers)
+		}
+	}
+	return profile, nil
+}
+
+// Recommend returns the pies that can be recommended to a user
+func (s *RedisStore) Recommend(username string, query LabelQuery) (pie.RecommendPies, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	// List of sets we are going to intersect with to narrow down the pies
+	// we can recommend to the user
+	sets := []interface{}{
+		s.key(PiesAvailableKey),
+	}
+
+	// Check to see if there is a list of pies available to a user
+	userAvailableKey := s.key(UserAvailableKey, username)
+	exists, err := redis.Bool(conn.Do("EXISTS", userAvailableKey))
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing can be recommended once the user reached the daily limit
+	if s.limits.PerDay > 0 {
+		purchasedToday, err := redis.Int(conn.Do("GET", s.key(UserDailyKey, username, s.limits.today())))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		if purchasedToday >= s.limits.PerDay {
+			return pie.RecommendPies{}, nil
+		}
+	}
+
+	// Filter by pies available to current user if possible
+	log.Printf("debug: userAvailableKey=%v\n", userAvailableKey)
+	if exists {
+		sets = append(sets, userAvailableKey)
+	}
+
+	log.Printf("debug: sets=%v, query=%+v\n", sets, query)
+
+	// Query redis for the intersecting pies
+	recommendedPieIDs, err := s.queryLabels(conn, sets, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all the pies to recommend
+	listOfPies := make(pie.RecommendPies, len(recommendedPieIDs))
+
+	// Get the pie details
+	for index, id := range recommendedPieIDs {
+		pKey := s.key(HPieKey, id)
+		values, err := redis.Values(conn.Do("HMGET", pKey, "id", "name", "price_cents"))
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := redis.Uint64(values[0], nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not get pie id: err=%q", err)
+		}
+
+		name, err := redis.String(values[1], nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not get pie name: err=%q", err)
+		}
+
+		price, err := redis.Int64(values[2], nil)
+		if err == redis.ErrNil {
+			return nil, fmt.Errorf("pie %d: the price is not stored in cents, re-ingest the catalog with -i", id)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not get pie price: err=%q", err)
+		}
+
+		remaining, err := redis.Int(conn.Do("GET", s.key(PieSlicesKey, strconv.FormatUint(id, 10))))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+
+		listOfPies[index] = &pie.RecommendPie{
+			ID:              id,
+			Name:            name,
+			Price:           pie.Cents(price),
+			RemainingSlices: remaining,
+		}
+	}
+	return listOfPies, nil
+}
@@ -1,4 +1,4 @@
-package api
+package store
 
 // PiesAvailableKey is the key representing the set of available pies left to purchase
 const PiesAvailableKey = "pies:available"
@@ -13,7 +13,8 @@ const PiesTotalKey = "pies:total"
 // JSON stringified representation
 const PieKey string = "pie:%s"
 
-// HPieKey is the formatted string that represents the key to get a specific pie and it's fields
+// HPieKey is the formatted string that represents the key to get a specific pie and it's fields.
+// The price is stored in cents under price_cents.
 const HPieKey string = "hpie:%s"
 
 // PieSlicesKey is the formatted string that represents the key to get the number
@@ -38,5 +39,40 @@ const UserAvailableKey = "user:%s:available"
 
 // UserUnavailableKey is the formatted string that represents the key to the
 // number of remaining pies that are no longer available to the user due to
-// max consumption of slices (3)
+// reaching the maximum number of slices they may buy of that pie
 const UserUnavailableKey = "user:%s:unavailable"
+
+// UserDailyKey is the formatted string that represents the key to the number
+// of slices across all pies a user purchased on a given day
+const UserDailyKey = "user:%s:daily:%s"
+
+// OrdersNextKey is the key to the counter used to give every order an ID
+const OrdersNextKey = "orders:next"
+
+// UserOrdersKey is the formatted string that represents the key to the list
+// of IDs of the orders made by a user
+const UserOrdersKey = "user:%s:orders"
+
+// DayOrdersKey is the key to the list of IDs of the orders made since the
+// last reset
+const DayOrdersKey = "orders:day"
+
+// QueriesNextKey is the key to the counter used to name the temporary keys
+// of label queries
+const QueriesNextKey = "queries:next"
+
+// QueryKey is the formatted string that represents a temporary key used while
+// running a label query
+const QueryKey = "query:%d:%s"
+
+// SalesKey is the formatted string that represents the key to the archived
+// sales of a day
+const SalesKey = "sales:%s"
+
+// OrderKey is the formatted string that represents the key to the JSON
+// record of an order
+const OrderKey = "order:%s"
+
+// IdempotencyKey is the formatted string that represents the key to the
+// response saved for an idempotency key sent with a request by a user
+const IdempotencyKey = "idempotency:%s:%s"
@@ -0,0 +1,278 @@
+package store
+
+import "github.com/garyburd/redigo/redis"
+
+// Outcomes of purchasing a single pie returned by the checkout script
+const (
+	purchaseOK         = "ok"
+	purchaseNotFound   = "notfound"
+	purchaseGluttony   = "gluttony"
+	purchaseSoldOut    = "soldout"
+	purchaseNotEnough  = "notenough"
+	purchaseWrongMaths = "wrongmaths"
+)
+
+// Outcomes returned by the checkout script
+const (
+	checkoutOK       = "ok"
+	checkoutRejected = "rejected"
+)
+
+// Outcomes returned by the refund script
+const (
+	refundOK           = "ok"
+	refundNotFound     = "notfound"
+	refundNotPurchased = "notpurchased"
+)
+
+// Outcomes returned by the restock script
+const (
+	restockOK       = "ok"
+	restockNotFound = "notfound"
+)
+
+// restockScript adds slices to a pie and adds it back to the pies available
+// to everyone and to every user who has not reached their limit for it.
+// It returns the outcome and the number of remaining slices.
+//
+// KEYS: hpie, pie slices, pies available, followed by the available and
+// unavailable pies of each user
+// ARGV: pie id, slices
+var restockScript = redis.NewScript(-1, `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return {"notfound", 0}
+end
+
+local remaining = redis.call("INCRBY", KEYS[2], ARGV[2])
+if remaining > 0 then
+	redis.call("SADD", KEYS[3], ARGV[1])
+	for i = 4, #KEYS, 2 do
+		if redis.call("SISMEMBER", KEYS[i + 1], ARGV[1]) == 0 then
+			redis.call("SADD", KEYS[i], ARGV[1])
+		end
+	end
+end
+return {"ok", remaining}
+`)
+
+// refundScript gives slices purchased by a user back to the pie and makes
+// the pie available again to everyone, including the user.
+// The slices are recorded as refunded on the lines of the orders of the user,
+// from the most recent order, and refunded at the price paid for them.
+// It returns the outcome, the number of slices refunded and the amount in cents.
+//
+// KEYS: pie, hpie, pie slices, pie purchasers, user purchases of the pie,
+// user available, user unavailable, pies available, user purchases today,
+// followed by the orders of the user from the most recent
+// ARGV: pie id, username, slices to refund (0 refunds everything)
+var refundScript = redis.NewScript(-1, `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return {"notfound", 0, 0}
+end
+
+local price = tonumber(redis.call("HGET", KEYS[2], "price_cents"))
+if not price then
+	return redis.error_reply("pie " .. ARGV[1] .. ": the price is not stored in cents, re-ingest the catalog with -i")
+end
+
+local purchased = tonumber(redis.call("GET", KEYS[5]) or "0")
+local slices = tonumber(ARGV[3])
+if slices == 0 then
+	slices = purchased
+end
+if purchased == 0 or slices > purchased then
+	return {"notpurchased", 0, 0}
+end
+
+redis.call("INCRBY", KEYS[3], slices)
+if purchased == slices then
+	redis.call("DEL", KEYS[5])
+	redis.call("SREM", KEYS[4], ARGV[2])
+else
+	redis.call("DECRBY", KEYS[5], slices)
+end
+redis.call("SADD", KEYS[8], ARGV[1])
+
+-- the refunded slices no longer count towards the daily limit
+local purchasedToday = tonumber(redis.call("GET", KEYS[9]) or "0")
+if purchasedToday > 0 then
+	redis.call("DECRBY", KEYS[9], math.min(slices, purchasedToday))
+end
+
+-- the user had reached the limit and can buy the pie again
+if redis.call("SREM", KEYS[7], ARGV[1]) == 1 then
+	if redis.call("SCARD", KEYS[7]) == 0 then
+		redis.call("DEL", KEYS[6])
+	else
+		redis.call("SDIFFSTORE", KEYS[6], KEYS[8], KEYS[7])
+	end
+elseif redis.call("EXISTS", KEYS[6]) == 1 then
+	redis.call("SADD", KEYS[6], ARGV[1])
+end
+
+local amount = 0
+local left = slices
+for i = 10, #KEYS do
+	local record = redis.call("GET", KEYS[i])
+	if left > 0 and record then
+		local order = cjson.decode(record)
+		local changed = false
+		for _, line in ipairs(order.lines) do
+			local refundable = line.slices - (line.refunded or 0)
+			if left > 0 and line.pie_id == ARGV[1] and refundable > 0 then
+				local refunded = math.min(refundable, left)
+				line.refunded = (line.refunded or 0) + refunded
+				amount = amount + line.unit_price_cents * refunded
+				left = left - refunded
+				changed = true
+			end
+		end
+		if changed then
+			redis.call("SET", KEYS[i], cjson.encode(order))
+		end
+	end
+end
+
+-- slices bought before orders were recorded are refunded at the current price
+if left > 0 then
+	amount = amount + price * left
+end
+return {"ok", slices, amount}
+`)
+
+// checkoutScript checks that a user may buy the slices of every line of an
+// order and only purchases them when all of them can be purchased, in one
+// atomic step so it never has to be retried under contention.
+// On success it saves the order record and returns "ok" with the record.
+// Otherwise it returns "rejected" with the outcome for the order as a whole
+// (empty unless only the order as a whole was rejected) and the outcome of
+// every rejected line. An outcome is followed by the limit that was hit, the
+// value of the limit and how many slices the user can still buy.
+//
+// KEYS: user available, user unavailable, pies available, user purchases today,
+// order, user orders, orders of the day, followed by pie, hpie, pie slices,
+// pie purchasers and user purchases of the pie for every line
+// ARGV: username, amount in cents, max slices per user, max slices per day
+// (0 for no limit), seconds to keep the purchases today, order id, time of the
+// order, followed by pie id and wanted slices for every line
+var checkoutScript = redis.NewScript(-1, `
+local username = ARGV[1]
+local amount = tonumber(ARGV[2])
+local defaultMax = tonumber(ARGV[3])
+local daily = tonumber(ARGV[4])
+local purchasedToday = tonumber(redis.call("GET", KEYS[4]) or "0")
+
+local lines = {}
+local rejected = {}
+local total = 0
+local totalSlices = 0
+for i = 1, (#KEYS - 7) / 5 do
+	local k = 7 + (i - 1) * 5
+	local pieID = ARGV[6 + i * 2]
+	local wanted = tonumber(ARGV[7 + i * 2])
+	totalSlices = totalSlices + wanted
+
+	if redis.call("EXISTS", KEYS[k + 1]) == 0 then
+		table.insert(rejected, {pieID, "notfound", "", 0, 0})
+	else
+		local max = tonumber(redis.call("HGET", KEYS[k + 2], "max") or "0")
+		if max == 0 then
+			max = defaultMax
+		end
+		local purchased = tonumber(redis.call("GET", KEYS[k + 5]) or "0")
+		local remaining = tonumber(redis.call("GET", KEYS[k + 3]) or "0")
+		local price = tonumber(redis.call("HGET", KEYS[k + 2], "price_cents"))
+		local name = redis.call("HGET", KEYS[k + 2], "name")
+		if not price then
+			return redis.error_reply("pie " .. pieID .. ": the price is not stored in cents, re-ingest the catalog with -i")
+		end
+
+		local allowance = max - purchased
+		if daily > 0 and daily - purchasedToday < allowance then
+			allowance = daily - purchasedToday
+		end
+		if allowance < 0 then
+			allowance = 0
+		end
+
+		if purchased + wanted > max then
+			table.insert(rejected, {pieID, "gluttony", "pie", max, allowance})
+		elseif daily > 0 and purchasedToday + wanted > daily then
+			table.insert(rejected, {pieID, "gluttony", "daily", daily, allowance})
+		elseif remaining == 0 then
+			table.insert(rejected, {pieID, "soldout", "", 0, 0})
+		elseif wanted > remaining then
+			table.insert(rejected, {pieID, "notenough", "", 0, 0})
+		else
+			total = total + price * wanted
+			table.insert(lines, {k, pieID, wanted, price, purchased, max, remaining, name})
+		end
+	end
+end
+
+if #rejected > 0 then
+	return {"rejected", {}, rejected}
+end
+
+-- the lines may be within the daily limit on their own but not together
+if daily > 0 and purchasedToday + totalSlices > daily then
+	return {"rejected", {"gluttony", "daily", daily, math.max(daily - purchasedToday, 0)}, {}}
+end
+
+if total ~= amount then
+	return {"rejected", {"wrongmaths", "", 0, 0}, {}}
+end
+
+local order = {id = ARGV[6], username = username, created_at = ARGV[7], lines = {}}
+local unavailable = false
+for _, l in ipairs(lines) do
+	local k, pieID, wanted, price, purchased, max, remaining, name = unpack(l)
+	redis.call("DECRBY", KEYS[k + 3], wanted)
+	redis.call("INCRBY", KEYS[k + 5], wanted)
+	redis.call("SADD", KEYS[k + 4], username)
+
+	-- the pie is no longer available once every slice is sold
+	if remaining - wanted == 0 then
+		redis.call("SREM", KEYS[3], pieID)
+	end
+
+	-- the user can not buy any more of this pie
+	if purchased + wanted >= max then
+		redis.call("SADD", KEYS[2], pieID)
+		unavailable = true
+	end
+	table.insert(order.lines, {pie_id = pieID, name = name, slices = wanted, unit_price_cents = price})
+end
+
+if unavailable then
+	redis.call("SDIFFSTORE", KEYS[1], KEYS[3], KEYS[2])
+end
+redis.call("INCRBY", KEYS[4], totalSlices)
+redis.call("EXPIRE", KEYS[4], ARGV[5])
+
+local record = cjson.encode(order)
+redis.call("SET", KEYS[5], record)
+redis.call("RPUSH", KEYS[6], ARGV[6])
+redis.call("RPUSH", KEYS[7], ARGV[6])
+return {"ok", record}
+`)
+
+// extendClaimScript extends the lease of an idempotency key as long as it is
+// still claimed by the same attempt and no response was saved for it.
+// It returns 1 when the lease was extended.
+//
+// KEYS: idempotency key
+// ARGV: claim, lease in milliseconds
+var extendClaimScript = redis.NewScript(1, `
+local saved = redis.call("GET", KEYS[1])
+if not saved then
+	return 0
+end
+
+local resp = cjson.decode(saved)
+if resp.claim ~= ARGV[1] or resp.status_code ~= 0 then
+	return 0
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return 1
+`)
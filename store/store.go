@@ -0,0 +1,335 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davinche/gpies/config"
+	"github.com/davinche/gpies/pie"
+)
+
+// ErrNotFound is returned when the requested pie does not exist
+var ErrNotFound = errors.New("pie not found")
+
+// ErrPieExists is returned when creating a pie with the id of an existing pie
+var ErrPieExists = errors.New("pie already exists")
+
+// ErrOrderNotFound is returned when the requested order does not exist
+var ErrOrderNotFound = errors.New("order not found")
+
+// ErrSoldOut is returned when a pie has no slices left
+var ErrSoldOut = errors.New("no more of that pie")
+
+// ErrNotEnoughSlices is returned when there are not enough slices left to
+// fulfill a purchase
+var ErrNotEnoughSlices = errors.New("not enough remaining slices")
+
+// ErrNotPurchased is returned when a user tries to refund more slices than
+// they purchased
+var ErrNotPurchased = errors.New("user has not purchased that many slices")
+
+// ErrInvalidSlices is returned when an order has no lines or a line does
+// not have a positive number of slices
+var ErrInvalidSlices = errors.New("slices must be a positive integer")
+
+// ErrWrongMaths is returned when the amount paid does not match the price
+// of the slices being purchased
+var ErrWrongMaths = errors.New("amount does not match price")
+
+// PieStore is the storage used by the API and ingestion to read and update
+// the catalog of pies, the remaining slices and the purchases made by users.
+type PieStore interface {
+	// Load replaces everything in the store with the given catalog
+	Load(pies pie.Pies) error
+
+	// Upsert merges the given catalog into the store without losing sales
+	Upsert(pies pie.Pies, opts UpsertOptions) error
+
+	// EditPie changes a single pie of the catalog in one transaction.
+	// edit receives a copy of the stored pie, nil when there is none, and
+	// returns the pie to save, or nil to retire it. The remaining slices of
+	// an existing pie are left alone. An error returned by edit aborts the
+	// change and is returned as is.
+	EditPie(id uint64, edit func(old *pie.Pie) (*pie.Pie, error)) error
+
+	// Catalog returns the pies as they were ingested
+	Catalog() (pie.Pies, error)
+
+	// Pies returns the pies of the catalog selected by the label query with
+	// the remaining slices of each pie
+	Pies(query LabelQuery) (pie.Pies, error)
+
+	// Labels returns every label carried by a pie of the catalog along with
+	// the number of pies carrying it and how many of them are in stock,
+	// sorted by name
+	Labels() ([]*pie.Label, error)
+
+	// Pie returns the details and purchases of a single pie
+	Pie(id string) (*pie.Details, error)
+
+	// Purchase buys slices of a pie on behalf of a user and returns the order.
+	// A LimitError is returned when the user would buy too many slices.
+	Purchase(id, username string, amount pie.Cents, slices int) (*pie.Order, error)
+
+	// Checkout buys the slices of every line of an order on behalf of a user.
+	// Either every line is purchased or none is, in which case a
+	// CheckoutError with the reasons is returned. ErrInvalidSlices is
+	// returned when a line does not buy at least one slice.
+	Checkout(username string, lines []*pie.OrderLine, amount pie.Cents) (*pie.Order, error)
+
+	// Order returns the record of an order
+	Order(id string) (*pie.Order, error)
+
+	// History returns every purchase made by a user and how many more
+	// slices of each pie they may buy
+	History(username string) (*pie.History, error)
+
+	// Restock adds slices to a pie and returns its remaining slices
+	Restock(id string, slices int) (int, error)
+
+	// Reset starts a new day: it archives the sales of the day, sets the
+	// remaining slices of every pie to the given number, or its catalog
+	// slices when it is not given, and clears the purchases of every user
+	Reset(day string, slices map[uint64]int) (*pie.DailySales, error)
+
+	// Refund gives slices of a pie purchased by a user back to the pie.
+	// All of the user's slices are refunded when slices is 0.
+	Refund(id, username string, slices int) (*pie.Refund, error)
+
+	// ClaimIdempotencyKey claims a key of a user for the request for the
+	// given lease, after which the key can be claimed again unless the lease
+	// was extended or a response was saved. The claim identifies the attempt
+	// that holds the key. When the key was already claimed the saved response
+	// is returned instead, which has no status code while the first request
+	// is still in progress.
+	ClaimIdempotencyKey(username, key, request, claim string, lease time.Duration) (*SavedResponse, bool, error)
+
+	// ExtendIdempotencyKey extends the lease of a key as long as it is still
+	// held by the claim, and reports whether it is
+	ExtendIdempotencyKey(username, key, claim string, lease time.Duration) (bool, error)
+
+	// SaveIdempotentResponse saves the response to replay for a claimed key
+	// for the given window
+	SaveIdempotentResponse(username, key string, resp *SavedResponse, window time.Duration) error
+
+	// ReleaseIdempotencyKey forgets a claimed key so the request can be retried
+	ReleaseIdempotencyKey(username, key string) error
+
+	// Profile returns the pies a user bought according to their orders along
+	// with the pies bought by the other users who bought the same pies
+	Profile(username string) (*Profile, error)
+
+	// Recommend returns the pies that are still available to a user and
+	// are selected by the label query
+	Recommend(username string, query LabelQuery) (pie.RecommendPies, error)
+}
+
+// UpsertOptions controls how Upsert treats existing pies
+type UpsertOptions struct {
+	// Retire removes the pies that are not part of the incoming catalog
+	Retire bool
+
+	// Restock resets the remaining slices of existing pies to the
+	// number of slices in the incoming catalog
+	Restock bool
+}
+
+// catalogChange describes what happens to a single pie during an upsert.
+// Old is nil for new pies and New is nil for retired pies.
+type catalogChange struct {
+	ID      uint64
+	Old     *pie.Pie
+	New     *pie.Pie
+	Retired bool
+}
+
+// dailySales returns the sales of the pies given their remaining slices and
+// the orders of the day. Slices are counted at the price paid for them and
+// refunded slices are left out.
+//
+// Purchases are cleared on reset, so only the slices of the orders since the
+// last reset can be refunded and a refund is always taken off the sales of
+// the day of its order.
+func dailySales(day string, pies pie.Pies, orders []*pie.Order) *pie.DailySales {
+	sales := &pie.DailySales{Day: day, Pies: []*pie.Sales{}}
+	byID := map[uint64]*pie.Sales{}
+	for _, p := range pies {
+		pieSales := &pie.Sales{PieID: p.ID, Name: p.Name, Remaining: p.Slices}
+		byID[p.ID] = pieSales
+		sales.Pies = append(sales.Pies, pieSales)
+	}
+
+	purchasers := map[uint64]map[string]bool{}
+	for _, order := range orders {
+		for _, l := range order.Lines {
+			slices := l.Slices - l.Refunded
+			if slices == 0 {
+				continue
+			}
+
+			// Pies retired during the day are still part of the sales
+			pieSales, ok := byID[l.PieID]
+			if !ok {
+				pieSales = &pie.Sales{PieID: l.PieID, Name: l.Name}
+				byID[l.PieID] = pieSales
+				sales.Pies = append(sales.Pies, pieSales)
+			}
+
+			pieSales.Slices += slices
+			pieSales.Amount += l.UnitPrice.Times(slices)
+			if purchasers[l.PieID] == nil {
+				purchasers[l.PieID] = map[string]bool{}
+			}
+			if !purchasers[l.PieID][order.Username] {
+				purchasers[l.PieID][order.Username] = true
+				pieSales.Purchasers++
+			}
+		}
+	}
+	return sales
+}
+
+// editCatalog replaces, adds or retires a single pie of the catalog.
+// The pie is retired when edited is nil.
+func editCatalog(stored pie.Pies, id uint64, edited *pie.Pie) (pie.Pies, []*catalogChange) {
+	merged := pie.Pies{}
+	change := &catalogChange{ID: id, New: edited, Retired: edited == nil}
+	for _, p := range stored {
+		if p.ID != id {
+			merged = append(merged, p)
+			continue
+		}
+
+		change.Old = p
+		if edited != nil {
+			merged = append(merged, edited)
+		}
+	}
+
+	if change.Old == nil && edited != nil {
+		merged = append(merged, edited)
+	}
+	if change.Old == nil && edited == nil {
+		return merged, []*catalogChange{}
+	}
+	return merged, []*catalogChange{change}
+}
+
+// mergeCatalog merges the incoming pies into the stored catalog and returns
+// the resulting catalog along with the changes to apply.
+// Stored pies keep their position and new pies are appended.
+func mergeCatalog(stored, incoming pie.Pies, opts UpsertOptions) (pie.Pies, []*catalogChange) {
+	incomingByID := map[uint64]*pie.Pie{}
+	for _, p := range incoming {
+		incomingByID[p.ID] = p
+	}
+
+	merged := pie.Pies{}
+	changes := []*catalogChange{}
+	storedIDs := map[uint64]bool{}
+	for _, old := range stored {
+		storedIDs[old.ID] = true
+		p, ok := incomingByID[old.ID]
+		if !ok {
+			if opts.Retire {
+				changes = append(changes, &catalogChange{ID: old.ID, Old: old, Retired: true})
+			} else {
+				merged = append(merged, old)
+			}
+			continue
+		}
+		merged = append(merged, p)
+		changes = append(changes, &catalogChange{ID: p.ID, Old: old, New: p})
+	}
+
+	for _, p := range incoming {
+		if storedIDs[p.ID] {
+			continue
+		}
+		merged = append(merged, p)
+		changes = append(changes, &catalogChange{ID: p.ID, New: p})
+	}
+	return merged, changes
+}
+
+// removedLabels returns the labels in old that are no longer in labels
+func removedLabels(old, labels []string) []string {
+	removed := []string{}
+	for _, l := range old {
+		found := false
+		for _, n := range labels {
+			if l == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			removed = append(removed, l)
+		}
+	}
+	return removed
+}
+
+// SavedResponse is the response replayed for a repeated idempotency key.
+// While the first request is in progress it only holds the request and the
+// claim of the attempt performing it.
+type SavedResponse struct {
+	Request    string              `json:"request"`
+	Claim      string              `json:"claim,omitempty"`
+	StatusCode int                 `json:"status_code"`
+	Header     map[string][]string `json:"header"`
+	Body       []byte              `json:"body"`
+}
+
+// timeOfDay parses a local time, eg: "05:00", into the time since midnight.
+// An empty time is midnight.
+func timeOfDay(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q is not formatted as HH:MM", name, value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// New creates the PieStore selected in the configuration
+func New() (PieStore, error) {
+	limits := Limits{
+		PerPie: config.Config.MaxSlicesPerUser,
+		PerDay: config.Config.MaxSlicesPerDay,
+	}
+
+	// Every instance counts the daily limit from the same time of the day,
+	// and the daily limit starts over when the store is reset
+	dayStart, err := timeOfDay("day start", config.Config.DayStart)
+	if err != nil {
+		return nil, err
+	}
+	if config.Config.ResetTime != "" {
+		resetTime, err := timeOfDay("reset time", config.Config.ResetTime)
+		if err != nil {
+			return nil, err
+		}
+		if resetTime != dayStart {
+			return nil, fmt.Errorf("reset time %q is not the day start %q", config.Config.ResetTime, config.Config.DayStart)
+		}
+	}
+	limits.DayStart = dayStart
+
+	switch config.Config.Store {
+	case "", "redis":
+		return NewRedisStore(RedisOptions{
+			Host:      config.Config.Redis,
+			Password:  config.Config.RedisPassword,
+			DB:        config.Config.RedisDB,
+			Namespace: config.Config.Namespace,
+		}, limits)
+	case "memory":
+		return NewMemoryStore(limits), nil
+	}
+	return nil, fmt.Errorf("unknown store %q", config.Config.Store)
+}
@@ -0,0 +1,118 @@
+package taxonomy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/davinche/gpies/config"
+	"github.com/kardianos/osext"
+)
+
+// Taxonomy defines how labels relate to each other
+type Taxonomy struct {
+	// Implies lists the labels implied by a label, eg: every vegan pie is
+	// also vegetarian. Implications are followed transitively.
+	Implies map[string][]string `json:"implies"`
+
+	// Aliases maps other spellings of a label to the label, eg: gluten-free
+	// to gluten_free
+	Aliases map[string]string `json:"aliases"`
+}
+
+// Load reads the taxonomy of the config. It defaults to the taxonomy.json
+// next to the binary and no taxonomy is used when that file does not exist.
+// Relative paths are relative to the folder of the binary.
+func Load() (*Taxonomy, error) {
+	execDir, err := osext.ExecutableFolder()
+	if err != nil {
+		return nil, err
+	}
+
+	path := config.Config.Taxonomy
+	if path == "" {
+		path = filepath.Join(execDir, "taxonomy.json")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	} else if !filepath.IsAbs(path) {
+		path = filepath.Join(execDir, path)
+	}
+	return FromFile(path)
+}
+
+// FromFile reads a taxonomy and makes sure every alias maps to a label
+// that is not itself an alias
+func FromFile(path string) (*Taxonomy, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	t := &Taxonomy{}
+	err = json.NewDecoder(file).Decode(t)
+	if err != nil {
+		return nil, err
+	}
+
+	for alias, label := range t.Aliases {
+		if _, ok := t.Aliases[label]; ok {
+			return nil, fmt.Errorf("alias %q maps to %q which is also an alias", alias, label)
+		}
+	}
+	return t, nil
+}
+
+// Canonical returns the label an alias stands for, or the label itself
+func (t *Taxonomy) Canonical(label string) string {
+	if t == nil {
+		return label
+	}
+	if canonical, ok := t.Aliases[label]; ok {
+		return canonical
+	}
+	return label
+}
+
+// Normalize replaces the aliases by their label and removes duplicates.
+// It is used on labels that are queried.
+func (t *Taxonomy) Normalize(labels []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, l := range labels {
+		l = t.Canonical(l)
+		if !seen[l] {
+			seen[l] = true
+			normalized = append(normalized, l)
+		}
+	}
+	return normalized
+}
+
+// Expand normalizes the labels and adds every label they imply after them.
+// It is used on the labels of the pies that are ingested.
+func (t *Taxonomy) Expand(labels []string) []string {
+	expanded := t.Normalize(labels)
+	if t == nil {
+		return expanded
+	}
+
+	seen := map[string]bool{}
+	for _, l := range expanded {
+		seen[l] = true
+	}
+
+	// expanded grows while it is walked so implied labels are followed too
+	for i := 0; i < len(expanded); i++ {
+		for _, implied := range t.Implies[expanded[i]] {
+			implied = t.Canonical(implied)
+			if !seen[implied] {
+				seen[implied] = true
+				expanded = append(expanded, implied)
+			}
+		}
+	}
+	return expanded
+}